@@ -0,0 +1,72 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/ratelimit"
+	"github.com/cblomart/ACMECA/noncestore"
+	"github.com/cblomart/ACMECA/objectstore"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval is the interval between two runs of the cron tasks
+	Interval = time.Minute * 5
+	// Retention is the time during which expired objects are kept
+	Retention = time.Hour * 24 * 30
+)
+
+// Start runs the cron tasks periodically
+func Start(os objectstore.ObjectStore, ns noncestore.NonceStore, interval time.Duration) {
+	log.Infof("running cron tasks every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			Run(os, ns)
+		}
+	}()
+}
+
+// Run runs the cron tasks once
+func Run(os objectstore.ObjectStore, ns noncestore.NonceStore) {
+	now := time.Now()
+	// expiry
+	count, err := os.ExpireOrders(now)
+	if err != nil {
+		log.Errorf("cron: cannot expire orders: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: expired %d orders", count)
+	}
+	count, err = os.ExpireAuthorizations(now)
+	if err != nil {
+		log.Errorf("cron: cannot expire authorizations: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: expired %d authorizations", count)
+	}
+	// garbage collection
+	count, err = os.PurgeOrders(now.Add(-Retention))
+	if err != nil {
+		log.Errorf("cron: cannot purge orders: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: purged %d orders", count)
+	}
+	count, err = os.PurgeAuthorizations(now.Add(-Retention))
+	if err != nil {
+		log.Errorf("cron: cannot purge authorizations: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: purged %d authorizations", count)
+	}
+	count, err = os.PurgeRateEvents(now.Add(-ratelimit.MaxWindow()))
+	if err != nil {
+		log.Errorf("cron: cannot purge rate events: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: purged %d rate events", count)
+	}
+	count, err = ns.Clean()
+	if err != nil {
+		log.Errorf("cron: cannot clean nonces: %s", err)
+	} else if count > 0 {
+		log.Infof("cron: removed %d expired nonces", count)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"strings"
 
 	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/ep/eab"
 	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/acme/ratelimit"
 	"github.com/cblomart/ACMECA/middlewares/objectstore"
 	"github.com/cblomart/ACMECA/objectstore/objects"
 	"github.com/cblomart/ACMECA/objectstore/utils"
@@ -20,7 +22,8 @@ import (
 // Req is the request of an account
 type Req struct {
 	objects.Account
-	OnlyReturnExisting bool `json:"onlyReturnExisting"`
+	OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
 }
 
 // ToAccount converts request back to account
@@ -31,9 +34,16 @@ func (r *Req) ToAccount() objects.Account {
 		Contact:              r.Contact,
 		Status:               r.Status,
 		TermsOfServiceAgreed: r.TermsOfServiceAgreed,
+		ExternalAccountID:    r.ExternalAccountID,
+		Orders:               r.Orders,
 	}
 }
 
+// ordersURL is the url of the orders list of an account
+func ordersURL(url string, kid string) string {
+	return fmt.Sprintf("%s%s/%s", url, ep.OrdersPath, kid)
+}
+
 //Post handles post requests to the account endpoint
 func Post(c *gin.Context) {
 	// get information from jws
@@ -49,6 +59,10 @@ func Post(c *gin.Context) {
 	if tmp, ok := c.Get("key"); ok {
 		key = fmt.Sprintf("%s", tmp)
 	}
+	var jwsURL string
+	if tmp, ok := c.Get("url"); ok {
+		jwsURL = fmt.Sprintf("%s", tmp)
+	}
 	reqKid := strings.Trim(c.Param("id"), "/")
 	// kid should be the same as requested key (kid wins)
 	if len(kid) > 0 && kid != reqKid {
@@ -94,6 +108,7 @@ func Post(c *gin.Context) {
 			c.Status(http.StatusNotFound)
 			return
 		}
+		existing.Orders = ordersURL(url, existing.KeyID)
 		if reqAccount == nil {
 			// if accound found return it
 			c.JSON(http.StatusOK, existing)
@@ -103,12 +118,29 @@ func Post(c *gin.Context) {
 			c.JSON(http.StatusOK, existing)
 			return
 		}
+		// client initiated deactivation (RFC 8555 §7.3.6)
+		if reqAccount.Status == "deactivated" {
+			err = store.DeactivateAccount(kid)
+			if err != nil {
+				log.Errorf("cannot deactivate account %s: %s", kid, err)
+				problem.ServerInternal(c)
+				return
+			}
+			log.Infof("deactivated account %s", kid)
+			existing.Status = "deactivated"
+			c.JSON(http.StatusOK, existing)
+			return
+		}
+		if len(reqAccount.Status) > 0 && reqAccount.Status != existing.Status {
+			log.Errorf("account %s requested an invalid status change to %s", kid, reqAccount.Status)
+			problem.Send(c, problem.NewMalformed("account status can only be changed to deactivated"))
+			return
+		}
 		log.Infof("updating account %s", kid)
 		reqAccount.KeyID = existing.KeyID
 		reqAccount.Key = existing.Key
-		if len(reqAccount.Status) == 0 {
-			reqAccount.Status = existing.Status
-		}
+		reqAccount.Status = existing.Status
+		reqAccount.Orders = existing.Orders
 		if len(reqAccount.Contact) == 0 {
 			reqAccount.Contact = existing.Contact
 		}
@@ -119,6 +151,7 @@ func Post(c *gin.Context) {
 			problem.ServerInternal(c)
 			return
 		}
+		updated.Orders = existing.Orders
 		c.JSON(http.StatusOK, updated)
 		return
 	}
@@ -132,6 +165,13 @@ func Post(c *gin.Context) {
 		}
 		c.Header("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", url, ep.DirectoryPath))
 		if existing != nil {
+			existing.Orders = ordersURL(url, existing.KeyID)
+			// deactivated and revoked accounts cannot be used anymore
+			if existing.Status != "valid" {
+				log.Errorf("account %s with provided key is %s", existing.KeyID, existing.Status)
+				problem.Send(c, problem.NewUnauthorized(fmt.Sprintf("account is %s", existing.Status)))
+				return
+			}
 			// if accound found return it
 			c.JSON(http.StatusOK, existing)
 			return
@@ -142,13 +182,33 @@ func Post(c *gin.Context) {
 				c.Status(http.StatusNotFound)
 				return
 			}
+			// check external account binding
+			if len(reqAccount.ExternalAccountBinding) > 0 {
+				eabkid, err := eab.Verify(store, reqAccount.ExternalAccountBinding, key, jwsURL)
+				if err != nil {
+					log.Errorf("invalid external account binding: %s", err)
+					problem.Unauthorized(c)
+					return
+				}
+				log.Infof("account bound to external account %s", eabkid)
+				reqAccount.ExternalAccountID = eabkid
+				// binding is not part of the account
+				reqAccount.ExternalAccountBinding = nil
+			} else if eab.Required {
+				log.Errorf("external account binding required")
+				problem.ExternalAccountRequired(c)
+				return
+			}
+			if !ratelimit.Enforce(c, store, ratelimit.NewAccountsPerIP, ratelimit.RemoteIP(c)) {
+				return
+			}
 			// no account found so creating
 			reqAccount.KeyID = utils.ID()
 			//set headers
 			c.Header("Location", fmt.Sprintf("%s%s/%s", url, ep.AccountPath, reqAccount.KeyID))
 			reqAccount.Key = key
 			reqAccount.Status = "valid"
-			reqAccount.Orders = fmt.Sprintf("%s%s/%s", url, ep.OrderPath, reqAccount.KeyID)
+			reqAccount.Orders = ordersURL(url, reqAccount.KeyID)
 			err := store.CreateAccount(reqAccount.ToAccount())
 			if err != nil {
 				log.Errorf("cannot recover account: %s", err)
@@ -167,3 +227,41 @@ func Post(c *gin.Context) {
 	log.Warnf("payload: %s", payload)
 	c.Status(http.StatusNotImplemented)
 }
+
+// Revoke revokes an account on administrator request
+// pending authorizations and orders of the account are cancelled
+func Revoke(c *gin.Context) {
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot retrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	kid := strings.Trim(c.Param("id"), "/")
+	existing, err := store.GetAccount(kid)
+	if err != nil {
+		log.Errorf("cannot recover account with %s: %s", kid, err)
+		problem.ServerInternal(c)
+		return
+	}
+	if existing == nil {
+		log.Infof("no account found with id %s", kid)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if existing.Status != "valid" {
+		log.Errorf("cannot revoke account %s: account is %s", kid, existing.Status)
+		problem.Send(c, problem.NewMalformed(fmt.Sprintf("account is %s", existing.Status)))
+		return
+	}
+	err = store.RevokeAccount(kid)
+	if err != nil {
+		log.Errorf("cannot revoke account %s: %s", kid, err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("revoked account %s", kid)
+	existing.Status = "revoked"
+	existing.Orders = ordersURL(location.Get(c).String(), existing.KeyID)
+	c.JSON(http.StatusOK, existing)
+}
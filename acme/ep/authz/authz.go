@@ -1,20 +1,31 @@
 package authz
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/policy"
 	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/acme/ratelimit"
+	"github.com/cblomart/ACMECA/acme/validator"
 	"github.com/cblomart/ACMECA/middlewares/objectstore"
+	acmestore "github.com/cblomart/ACMECA/objectstore"
+	"github.com/cblomart/ACMECA/objectstore/objects"
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// Post handles a post request to order enpoint
+// Req is a pre-authorization request
+type Req struct {
+	Identifier objects.Identifier `json:"identifier"`
+}
+
+// Post handles a post request to authorization enpoint
 func Post(c *gin.Context) {
 	// get the use key id
 	var kid string
@@ -37,8 +48,7 @@ func Post(c *gin.Context) {
 	// check the id of the request
 	id := strings.Trim(c.Param("id"), "/")
 	if len(id) == 0 {
-		log.Errorf("no specific authorization requested")
-		problem.Malformed(c)
+		newAuthz(c, store, kid, url)
 		return
 	}
 	authz, err := store.GetAuthorization(id)
@@ -59,7 +69,146 @@ func Post(c *gin.Context) {
 		return
 	}
 	c.Header("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", url, ep.DirectoryPath))
+	// status change requested
+	var payload string
+	if tmp, ok := c.Get("payload"); ok {
+		payload = fmt.Sprintf("%s", tmp)
+	}
+	if len(payload) > 0 {
+		req := &objects.Authorization{}
+		err = json.Unmarshal([]byte(payload), req)
+		if err != nil {
+			log.Errorf("cannot unmarshal authorization update: %s", err)
+			problem.Malformed(c)
+			return
+		}
+		switch req.Status {
+		case "":
+		case "deactivated":
+			deactivate(c, store, authz)
+			return
+		default:
+			log.Errorf("invalid status change of authorization %s to %s", authz.ID, req.Status)
+			problem.Send(c, problem.NewMalformed("authorization status can only be changed to deactivated"))
+			return
+		}
+	}
 	log.Info(authz.String())
 	c.JSON(http.StatusOK, authz)
 	return
 }
+
+// deactivate deactivates an authorization and invalidates the orders depending on it
+func deactivate(c *gin.Context, store acmestore.ObjectStore, authz *objects.Authorization) {
+	if !authz.Deactivate() {
+		log.Errorf("cannot deactivate %s authorization %s", authz.Status, authz.ID)
+		problem.Send(c, problem.NewMalformed(fmt.Sprintf("authorization is %s", authz.Status)))
+		return
+	}
+	err := store.UpdateAuthorization(authz)
+	if err != nil {
+		log.Errorf("cannot update authorization %s: %s", authz.ID, err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("deactivated authorization %s", authz.ID)
+	orders, err := store.GetOrderByAuthorization(authz.ID)
+	if err != nil {
+		log.Errorf("cannot retrieve orders of authorization %s: %s", authz.ID, err)
+		problem.ServerInternal(c)
+		return
+	}
+	for _, o := range orders {
+		if o.Status != "pending" && o.Status != "ready" {
+			continue
+		}
+		err = store.InvalidateOrder(o.ID)
+		if err != nil {
+			log.Errorf("cannot invalidate order %s: %s", o.ID, err)
+			problem.ServerInternal(c)
+			return
+		}
+		log.Infof("invalidated order %s of deactivated authorization %s", o.ID, authz.ID)
+	}
+	c.JSON(http.StatusOK, authz)
+}
+
+// newAuthz creates an authorization before ordering (RFC 8555 §7.4.1)
+func newAuthz(c *gin.Context, store acmestore.ObjectStore, kid string, url string) {
+	var payload string
+	if tmp, ok := c.Get("payload"); ok {
+		payload = fmt.Sprintf("%s", tmp)
+	}
+	if len(payload) == 0 {
+		log.Errorf("recieved an empty authorization request")
+		problem.Malformed(c)
+		return
+	}
+	req := &Req{}
+	err := json.Unmarshal([]byte(payload), req)
+	if err != nil {
+		log.Errorf("cannot unmarshal authorization request: %s", err)
+		problem.Malformed(c)
+		return
+	}
+	// wildcards can only be authorized through an order
+	if _, wildcard := req.Identifier.Base(); wildcard {
+		log.Errorf("pre-authorization requested for wildcard %s", req.Identifier.Value)
+		problem.Send(c, problem.NewRejectedIdentifier("wildcard identifiers cannot be pre-authorized"))
+		return
+	}
+	// check identifier
+	rejected, unsupported := validator.CheckIdentifier(req.Identifier.String())
+	if unsupported {
+		log.Errorf("unsupported identifier: %s", req.Identifier.String())
+		problem.UnsupportedIdentifier(c)
+		return
+	}
+	if rejected {
+		log.Errorf("rejected identifier: %s", req.Identifier.String())
+		problem.RejectedIdentifier(c)
+		return
+	}
+	// check issuance policy
+	account, err := store.GetAccount(kid)
+	if err != nil || account == nil {
+		log.Errorf("cannot retrieve account %s: %s", kid, err)
+		problem.AccountDoesNotExist(c)
+		return
+	}
+	if denial := policy.Check(kid, account.ExternalAccountID, []string{req.Identifier.String()}); denial != nil {
+		log.Errorf("authorization denied by policy: %s", denial)
+		problem.Send(c, problem.NewRejectedIdentifier(denial.Detail))
+		return
+	}
+	hostname := strings.ToLower(req.Identifier.Value)
+	retry, err := ratelimit.Check(store, ratelimit.FailedValidationsPerHostname, hostname)
+	if err != nil {
+		log.Errorf("cannot check rate limit for %s: %s", hostname, err)
+		problem.ServerInternal(c)
+		return
+	}
+	if retry > 0 {
+		ratelimit.Limited(c, ratelimit.FailedValidationsPerHostname, hostname, retry)
+		return
+	}
+	if !ratelimit.Enforce(c, store, ratelimit.NewAuthorizationsPerAccount, kid) {
+		return
+	}
+	authz, err := objects.NewAuthorization(req.Identifier, kid, fmt.Sprintf("%s%s", url, ep.ChallengePath))
+	if err != nil {
+		log.Errorf("cannot create authorization: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	err = store.CreateAuthorization(authz)
+	if err != nil {
+		log.Errorf("cannot save authorization: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("pre-authorization created: %s", authz.String())
+	c.Header("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", url, ep.DirectoryPath))
+	c.Header("Location", fmt.Sprintf("%s%s/%s", url, ep.AuthzPath, authz.ID))
+	c.JSON(http.StatusCreated, authz)
+}
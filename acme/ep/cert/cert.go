@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/cblomart/ACMECA/acme/ep"
 	"github.com/cblomart/ACMECA/acme/problem"
 	"github.com/cblomart/ACMECA/middlewares/ca"
 	"github.com/cblomart/ACMECA/middlewares/certstore"
+	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 
 	log "github.com/sirupsen/logrus"
@@ -21,7 +24,21 @@ var client = http.Client{}
 // CertAccept is the accepted encoding of certificates
 const CertAccept = "application/pem-certificate-chain"
 
-// Get gets a certificate
+// chainIndex gets the index of the requested chain (0 for the default chain)
+func chainIndex(c *gin.Context) (int, error) {
+	param := strings.Trim(c.Param("chain"), "/")
+	if len(param) == 0 {
+		return 0, nil
+	}
+	index, err := strconv.Atoi(param)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid chain %s", param)
+	}
+	return index, nil
+}
+
+// Get gets a certificate with one of the issuer chains
+// other chains are announced in alternate links (RFC 8555 §7.4.2)
 func Get(c *gin.Context) {
 	id := c.Param("id")
 	if len(id) == 0 {
@@ -29,6 +46,29 @@ func Get(c *gin.Context) {
 		problem.Malformed(c)
 		return
 	}
+	index, err := chainIndex(c)
+	if err != nil {
+		log.Errorf("%s", err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	chains, err := ca.GetChains(c)
+	if err != nil {
+		log.Errorf("could not get issuer chains: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	if index >= len(chains) {
+		log.Errorf("chain %d not found", index)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	caurl, _, err := ca.GetInfo(c)
+	if err != nil {
+		log.Errorf("cannot find url of CA: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
 	// get certificate store
 	store, err := certstore.Get(c)
 	if err != nil {
@@ -36,7 +76,7 @@ func Get(c *gin.Context) {
 		problem.ServerInternal(c)
 		return
 	}
-	log.Infof("getting certitifcate: %s", id)
+	log.Infof("getting certitifcate: %s (chain %d)", id, index)
 	cert, err := store.GetCert(id)
 	if err != nil {
 		log.Errorf("could not get certificate from store: %s", err)
@@ -46,12 +86,26 @@ func Get(c *gin.Context) {
 	out := &bytes.Buffer{}
 	// encode certificate
 	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: *cert})
-	// encode ca certificate
-	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: store.GetCA().Raw})
+	// encode issuer chain
+	for _, issuer := range chains[index] {
+		pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})
+	}
+	// link to the other chains
+	for i := range chains {
+		if i == index {
+			continue
+		}
+		link := fmt.Sprintf("%s%s/%s", caurl, ep.CertPath, id)
+		if i > 0 {
+			link = fmt.Sprintf("%s/%d", link, i)
+		}
+		c.Writer.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"alternate\"", link))
+	}
 	c.Data(http.StatusOK, CertAccept, out.Bytes())
 }
 
 // ProxyGet gets a certificate via ca
+// alternate links of the ca are rewritten to this server
 func ProxyGet(c *gin.Context) {
 	id := c.Param("id")
 	if len(id) == 0 {
@@ -59,7 +113,13 @@ func ProxyGet(c *gin.Context) {
 		problem.Malformed(c)
 		return
 	}
-	log.Infof("getting certitifcate via CA: %s", id)
+	index, err := chainIndex(c)
+	if err != nil {
+		log.Errorf("%s", err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	log.Infof("getting certitifcate via CA: %s (chain %d)", id, index)
 	// call ca to issue certificate
 	caurl, _, err := ca.GetInfo(c)
 	if err != nil {
@@ -69,6 +129,9 @@ func ProxyGet(c *gin.Context) {
 	}
 	// path to csr to the ca
 	url := fmt.Sprintf("%s%s/%s", caurl, ep.CertPath, id)
+	if index > 0 {
+		url = fmt.Sprintf("%s/%d", url, index)
+	}
 	// create the request
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -80,11 +143,13 @@ func ProxyGet(c *gin.Context) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("error to cert request: %s", err)
-		if resp.StatusCode == http.StatusNotFound {
-			c.Status(http.StatusNotFound)
-		} else {
-			c.Status(http.StatusInternalServerError)
-		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		log.Errorf("cert request returned: %s", resp.Status)
+		c.Status(http.StatusNotFound)
 		return
 	}
 	if resp.StatusCode != http.StatusOK {
@@ -98,6 +163,12 @@ func ProxyGet(c *gin.Context) {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	// pass alternate links through
+	acmeurl := location.Get(c).String()
+	for _, link := range resp.Header.Values("Link") {
+		link = strings.Replace(link, fmt.Sprintf("<%s%s/", caurl, ep.CertPath), fmt.Sprintf("<%s%s/", acmeurl, ep.CertPath), 1)
+		c.Writer.Header().Add("Link", link)
+	}
 	c.Data(http.StatusOK, CertAccept, certchain)
 }
 
@@ -0,0 +1,167 @@
+package cert
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/middlewares/ca"
+	"github.com/cblomart/ACMECA/middlewares/certstore"
+	"github.com/gin-gonic/gin"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PageSize is the default number of certificates in a page of the inventory
+	PageSize = 100
+	// MaxPageSize is the maximum number of certificates in a page of the inventory
+	MaxPageSize = 1000
+	// CursorParam is the query parameter of the id after which certificates are listed
+	CursorParam = "cursor"
+	// LimitParam is the query parameter of the number of certificates in a page
+	LimitParam = "limit"
+	// NameParam is the query parameter of the dns name or ip address of certificates
+	NameParam = "name"
+	// SerialParam is the query parameter of the serial number of certificates
+	SerialParam = "serial"
+	// AccountParam is the query parameter of the issuing account of certificates
+	AccountParam = "account"
+	// ExpiresAfterParam is the query parameter of the date after which certificates expire
+	ExpiresAfterParam = "expiresAfter"
+	// ExpiresBeforeParam is the query parameter of the date before which certificates expire
+	ExpiresBeforeParam = "expiresBefore"
+	// IssuedAfterParam is the query parameter of the date after which certificates are valid from
+	IssuedAfterParam = "issuedAfter"
+	// IssuedBeforeParam is the query parameter of the date before which certificates are valid from
+	IssuedBeforeParam = "issuedBefore"
+	// StatusParam is the query parameter of the status of certificates (valid, expired or revoked)
+	StatusParam = "status"
+)
+
+// Info is the metadata of a certificate with the link to its pem chain
+type Info struct {
+	objects.Certificate
+	URL string `json:"url"`
+}
+
+// List is a page of the certificate inventory
+type List struct {
+	Certificates []Info `json:"certificates"`
+}
+
+// queryTime parses a date parameter as RFC 3339 or as a duration from now
+func queryTime(c *gin.Context, param string, now time.Time) (time.Time, error) {
+	value := c.Query(param)
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a RFC 3339 date or a duration: %s", param, value)
+	}
+	return now.Add(d), nil
+}
+
+// filter reads the inventory filter from the query parameters
+func filter(c *gin.Context, now time.Time) (*objects.Filter, error) {
+	f := &objects.Filter{
+		Name:    c.Query(NameParam),
+		Serial:  c.Query(SerialParam),
+		Account: c.Query(AccountParam),
+		Status:  c.Query(StatusParam),
+		Cursor:  c.Query(CursorParam),
+		Limit:   PageSize,
+	}
+	switch f.Status {
+	case "", objects.StatusValid, objects.StatusExpired, objects.StatusRevoked:
+	default:
+		return nil, fmt.Errorf("unknown status %s", f.Status)
+	}
+	var err error
+	f.ExpiresAfter, err = queryTime(c, ExpiresAfterParam, now)
+	if err != nil {
+		return nil, err
+	}
+	f.ExpiresBefore, err = queryTime(c, ExpiresBeforeParam, now)
+	if err != nil {
+		return nil, err
+	}
+	f.IssuedAfter, err = queryTime(c, IssuedAfterParam, now)
+	if err != nil {
+		return nil, err
+	}
+	f.IssuedBefore, err = queryTime(c, IssuedBeforeParam, now)
+	if err != nil {
+		return nil, err
+	}
+	if limit := c.Query(LimitParam); len(limit) > 0 {
+		f.Limit, err = strconv.Atoi(limit)
+		if err != nil || f.Limit < 1 || f.Limit > MaxPageSize {
+			return nil, fmt.Errorf("%s must be between 1 and %d", LimitParam, MaxPageSize)
+		}
+	}
+	return f, nil
+}
+
+// ListGet lists the certificates issued by the ca
+// the list is paginated with a cursor passed in the next link
+func ListGet(c *gin.Context) {
+	now := time.Now()
+	f, err := filter(c, now)
+	if err != nil {
+		log.Errorf("invalid certificate filter: %s", err)
+		problem.Send(c, problem.NewMalformed(err.Error()))
+		return
+	}
+	caurl, _, err := ca.GetInfo(c)
+	if err != nil {
+		log.Errorf("cannot find url of CA: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	store, err := certstore.Get(c)
+	if err != nil {
+		log.Errorf("could not get certificate store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	limit := f.Limit
+	// get one more certificate to know if there is a next page
+	f.Limit++
+	certs, err := store.ListCerts(*f)
+	if err != nil {
+		log.Errorf("cannot list certificates: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	list := List{Certificates: make([]Info, 0, limit)}
+	for i, cert := range certs {
+		if i == limit {
+			params := neturl.Values{}
+			for key, values := range c.Request.URL.Query() {
+				params[key] = values
+			}
+			params.Set(CursorParam, certs[i-1].ID)
+			next := fmt.Sprintf("%s%s?%s", caurl, ep.CertPath, params.Encode())
+			c.Writer.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"next\"", next))
+			break
+		}
+		cert.Status = cert.State(now)
+		list.Certificates = append(list.Certificates, Info{
+			Certificate: cert,
+			URL:         fmt.Sprintf("%s%s/%s", caurl, ep.CertPath, cert.ID),
+		})
+	}
+	log.Infof("returning %d certificates", len(list.Certificates))
+	c.JSON(http.StatusOK, list)
+}
@@ -7,7 +7,9 @@ import (
 
 	"github.com/cblomart/ACMECA/acme/ep"
 	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/acme/validator/worker"
 	"github.com/cblomart/ACMECA/middlewares/objectstore"
+	"github.com/cblomart/ACMECA/middlewares/validation"
 	"github.com/gin-gonic/gin"
 
 	"github.com/gin-contrib/location"
@@ -64,80 +66,45 @@ func Post(c *gin.Context) {
 		problem.AccountDoesNotExist(c)
 		return
 	}
-	// validating authorization's challenge
-	challenge := authz.Validate(id, account.Key)
+	// mark the challenge as processing
+	challenge := authz.Process(id)
 	if challenge == nil {
 		log.Infof("no challenge found with id %s", id)
 		c.Status(http.StatusNotFound)
 		return
 	}
-	// save authz
-	err = store.UpdateAuthorization(authz)
+	url := location.Get(c).String()
+	c.Header("Link", fmt.Sprintf("<%s%s/%s>;rel=\"up\"", url, ep.AuthzPath, authz.ID))
+	if challenge.Status != "processing" {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+	pool, err := validation.Get(c)
 	if err != nil {
-		log.Infof("could not update authorization")
+		log.Errorf("cannot retrieve validation workers: %s", err)
 		problem.ServerInternal(c)
 		return
 	}
-	if authz.Status == "valid" {
-		// check orders
-		orders, err := store.GetOrderByAuthorization(authz.ID)
+	// validations are queued once (challenges may be retried by the client)
+	if !pool.Processing(id) {
+		// save authz
+		err = store.UpdateAuthorization(authz)
 		if err != nil {
-			log.Infof("could not retrieve orders from authorization")
+			log.Errorf("could not update authorization: %s", err)
 			problem.ServerInternal(c)
 			return
 		}
-		// check orders
-		for _, order := range orders {
-			// only check pending orders
-			if order.Status != "pending" {
-				log.Warnf("Authorization updated for order not pending!")
-				continue
-			}
-			// check atuhtorization status for orders
-			valid := 0
-			invalid := 0 // includes revoked, expired, deactivated
-			pending := 0
-			url := location.Get(c).String()
-			basePath := fmt.Sprintf("%s%s/", url, ep.AuthzPath)
-			for _, orderAuthURL := range order.Authorizations {
-				if !strings.HasPrefix(orderAuthURL, basePath) {
-					log.Errorf("Authorization not pointing to this server auth basepath")
-					problem.ServerInternal(c)
-					return
-				}
-				id := strings.TrimPrefix(orderAuthURL, basePath)
-				orderAuthz, err := store.GetAuthorization(id)
-				if err != nil || orderAuthz == nil {
-					log.Errorf("Authorization with id %s not found", id)
-					problem.ServerInternal(c)
-					return
-				}
-				switch orderAuthz.Status {
-				case "valid":
-					valid++
-				case "pending":
-					pending++
-				case "invalid":
-					invalid++
-				default:
-					log.Errorf("Authorization in invalid state: %s", orderAuthz.Status)
-					invalid++
-				}
-			}
-			if invalid > 0 {
-				store.InvalidateOrder(order.ID)
-			} else if valid == len(order.Authorizations) {
-				store.ReadyOrder(order.ID)
-			}
-			if order.Status == "pending" {
-				continue
-			}
-			if err != nil {
-				log.Errorf("Could not update order %s", order.ID)
-				problem.ServerInternal(c)
-				return
-			}
+		err = pool.Submit(worker.Job{
+			Challenge: id,
+			Key:       account.Key,
+			AuthzPath: fmt.Sprintf("%s%s", url, ep.AuthzPath),
+		})
+		if err != nil {
+			log.Errorf("could not queue validation of challenge %s: %s", id, err)
+			problem.ServerInternal(c)
+			return
 		}
 	}
+	c.Header("Retry-After", fmt.Sprintf("%d", int(worker.RetryAfter.Seconds())))
 	c.JSON(http.StatusOK, challenge)
 }
@@ -0,0 +1,199 @@
+package crl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/problem"
+	acmestore "github.com/cblomart/ACMECA/certstore"
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/middlewares/ca"
+	"github.com/cblomart/ACMECA/middlewares/certstore"
+	"github.com/gin-gonic/gin"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Validity is the validity of a generated CRL
+	Validity = time.Hour * 24
+	// RefreshInterval is the interval between two CRL generations
+	RefreshInterval = time.Hour
+	// DERAccept is the encoding of a DER CRL
+	DERAccept = "application/pkix-crl"
+	// PEMAccept is the encoding of a PEM CRL
+	PEMAccept = "application/x-pem-file"
+)
+
+var (
+	client = http.Client{}
+	// oidReasonCode is the CRL entry extension for the revocation reason
+	oidReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+	// current generated CRL (DER)
+	current []byte
+	crlmux  sync.Mutex
+)
+
+// Generate generates the CRL from the revoked certificates in the store
+func Generate(store acmestore.CertStore, key interface{}) error {
+	revocations, err := store.GetRevocations()
+	if err != nil {
+		return fmt.Errorf("cannot list revocations: %s", err)
+	}
+	revoked := make([]pkix.RevokedCertificate, 0, len(revocations))
+	for _, revocation := range revocations {
+		serial, ok := new(big.Int).SetString(revocation.Serial, 10)
+		if !ok {
+			log.Errorf("invalid serial for revoked certificate %s: %s", revocation.ID, revocation.Serial)
+			continue
+		}
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: revocation.Revoked,
+		}
+		// unspecified reason should not be indicated (RFC 5280 §5.3.1)
+		if revocation.Reason != objects.ReasonUnspecified {
+			value, err := asn1.Marshal(asn1.Enumerated(revocation.Reason))
+			if err != nil {
+				return fmt.Errorf("cannot encode reason for %s: %s", revocation.ID, err)
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidReasonCode, Value: value}}
+		}
+		revoked = append(revoked, entry)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("ca key cannot sign crls")
+	}
+	// crl number is required in v2 crls (RFC 5280 §5.2.3)
+	number, err := store.NextCRLNumber()
+	if err != nil {
+		return fmt.Errorf("cannot get crl number: %s", err)
+	}
+	now := time.Now()
+	template := &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(number),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(Validity),
+	}
+	// the authority key identifier is taken from the ca certificate
+	der, err := x509.CreateRevocationList(rand.Reader, template, store.GetCA(), signer)
+	if err != nil {
+		return fmt.Errorf("cannot create crl: %s", err)
+	}
+	crlmux.Lock()
+	defer crlmux.Unlock()
+	current = der
+	log.Infof("generated crl %d with %d revoked certificates", number, len(revoked))
+	return nil
+}
+
+// Refresh regenerates the CRL periodically
+func Refresh(store acmestore.CertStore, key interface{}, interval time.Duration) {
+	err := Generate(store, key)
+	if err != nil {
+		log.Errorf("cannot generate crl: %s", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			err := Generate(store, key)
+			if err != nil {
+				log.Errorf("cannot generate crl: %s", err)
+			}
+		}
+	}()
+}
+
+// Get gets the CRL from the CA
+func Get(c *gin.Context) {
+	crlmux.Lock()
+	der := current
+	crlmux.Unlock()
+	if len(der) == 0 {
+		// CRL not generated yet
+		store, err := certstore.Get(c)
+		if err != nil {
+			log.Errorf("could not get certificate store: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		key, err := ca.GetSigning(c)
+		if err != nil {
+			log.Errorf("could not get signing infos: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		err = Generate(store, key)
+		if err != nil {
+			log.Errorf("cannot generate crl: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		crlmux.Lock()
+		der = current
+		crlmux.Unlock()
+	}
+	if strings.Contains(c.GetHeader("Accept"), PEMAccept) {
+		out := &bytes.Buffer{}
+		pem.Encode(out, &pem.Block{Type: "X509 CRL", Bytes: der})
+		c.Data(http.StatusOK, PEMAccept, out.Bytes())
+		return
+	}
+	c.Data(http.StatusOK, DERAccept, der)
+}
+
+// ProxyGet gets the CRL via the CA
+func ProxyGet(c *gin.Context) {
+	caurl, _, err := ca.GetInfo(c)
+	if err != nil {
+		log.Errorf("cannot find link to CA: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	// path to crl on the ca
+	url := fmt.Sprintf("%s%s", caurl, ep.CrlPath)
+	// create the request
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		log.Errorf("Cannot create request: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	if accept := c.GetHeader("Accept"); len(accept) > 0 {
+		req.Header.Add("Accept", accept)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("error to crl request: %s", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("crl request returned: %s", resp.Status)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	crl, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(crl) == 0 {
+		log.Errorf("crl returned is empty")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, resp.Header.Get("Content-Type"), crl)
+}
@@ -1,8 +1,8 @@
 package csr
 
 import (
-	"crypto/md5"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -10,12 +10,15 @@ import (
 	"io/ioutil"
 	"math/big"
 	"net/http"
+	neturl "net/url"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/cblomart/ACMECA/acme/ep"
 	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/acme/ratelimit"
+	"github.com/cblomart/ACMECA/certstore/utils"
 	"github.com/cblomart/ACMECA/middlewares/ca"
 	"github.com/cblomart/ACMECA/middlewares/certstore"
 	"github.com/cblomart/ACMECA/middlewares/objectstore"
@@ -27,8 +30,119 @@ import (
 
 var client = http.Client{}
 
-// ValidityPeriod is the period of validity of delivered certificates
-const ValidityPeriod = time.Hour * 24 * 30 * 3
+const (
+	// ValidityPeriod is the period of validity of delivered certificates
+	ValidityPeriod = time.Hour * 24 * 30 * 3
+	// Backdate is the time certificates are backdated to cope with clock skews
+	Backdate = time.Hour
+	// MaxStartDelay is how far in the future a requested validity can start
+	MaxStartDelay = time.Hour * 24 * 30
+	// AccountParam is the query parameter passing the account of the order to the CA
+	AccountParam = "account"
+	// OrderParam is the query parameter passing the order to the CA
+	OrderParam = "order"
+	// NotBeforeParam is the query parameter passing the start of the validity to the CA
+	NotBeforeParam = "notBefore"
+	// NotAfterParam is the query parameter passing the end of the validity to the CA
+	NotAfterParam = "notAfter"
+	// CertType is the content type of the certificate returned by the CA
+	CertType = "application/pkix-cert"
+)
+
+var (
+	// MaxValidity is the maximum lifetime of delivered certificates
+	MaxValidity = ValidityPeriod
+	// MinValidity is the minimum lifetime of delivered certificates
+	MinValidity = time.Hour * 24
+)
+
+// CheckValidity checks a requested validity against the lifetime policy
+// when the order is created (it is not checked again on finalization)
+// empty bounds are allowed and replaced by defaults on issuance
+func CheckValidity(notBefore *time.Time, notAfter *time.Time) error {
+	now := time.Now()
+	start := now
+	if notBefore != nil {
+		if notBefore.Before(now.Add(-Backdate)) {
+			return fmt.Errorf("notBefore cannot be more than %s in the past", Backdate)
+		}
+		if notBefore.After(now.Add(MaxStartDelay)) {
+			return fmt.Errorf("notBefore cannot be more than %s in the future", MaxStartDelay)
+		}
+		// lifetime is measured from the start of the certificate
+		start = *notBefore
+	}
+	if notAfter == nil {
+		return nil
+	}
+	if notBefore != nil && !notAfter.After(*notBefore) {
+		return fmt.Errorf("notAfter must be after notBefore")
+	}
+	lifetime := notAfter.Sub(start)
+	if lifetime > MaxValidity {
+		return fmt.Errorf("requested lifetime of %s exceeds the maximum of %s", lifetime.Round(time.Second), MaxValidity)
+	}
+	if lifetime < MinValidity {
+		return fmt.Errorf("requested lifetime of %s is below the minimum of %s", lifetime.Round(time.Second), MinValidity)
+	}
+	return nil
+}
+
+// CheckLifetime checks the validity of an issued certificate against the lifetime policy
+// the certificate lifetime cannot exceed the maximum and the backdating
+func CheckLifetime(start time.Time, end time.Time) error {
+	if !end.After(start) {
+		return fmt.Errorf("notAfter must be after notBefore")
+	}
+	lifetime := end.Sub(start)
+	if lifetime > MaxValidity+Backdate {
+		return fmt.Errorf("lifetime of %s exceeds the maximum of %s", lifetime.Round(time.Second), MaxValidity)
+	}
+	return nil
+}
+
+// Validity returns the validity of a certificate from the requested bounds
+func Validity(notBefore *time.Time, notAfter *time.Time) (time.Time, time.Time) {
+	now := time.Now()
+	start := now.Add(-Backdate)
+	end := now.Add(MaxValidity)
+	if notBefore != nil {
+		start = *notBefore
+		end = notBefore.Add(MaxValidity)
+	}
+	if notAfter != nil {
+		end = *notAfter
+	}
+	return start, end
+}
+
+// queryTime reads an optional time from the query parameters
+func queryTime(c *gin.Context, param string) (*time.Time, error) {
+	value := c.Query(param)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", param, value)
+	}
+	return &t, nil
+}
+
+// sameNames checks that requested names are the validated ones
+// validated names must be sorted
+func sameNames(requested []string, validated []string) bool {
+	if len(requested) != len(validated) {
+		return false
+	}
+	sort.Strings(requested)
+	for i := range requested {
+		if requested[i] != validated[i] {
+			return false
+		}
+	}
+	return true
+}
 
 // Payload represents the payload of a request
 type Payload struct {
@@ -53,7 +167,7 @@ func Post(c *gin.Context) {
 	}
 	// get order
 	url := location.Get(c).String()
-	order, err := store.GetOrder(id, fmt.Sprintf("%s/%s", url, ep.AuthzPath))
+	order, err := store.GetOrder(id, fmt.Sprintf("%s%s", url, ep.AuthzPath))
 	if err != nil || order == nil {
 		log.Errorf("cannot retrieve order: %s", err)
 		problem.ServerInternal(c)
@@ -124,16 +238,24 @@ func Post(c *gin.Context) {
 		problem.BadCSR(c)
 		return
 	}
-	// list validated identifier
-	dnsNames := make([]string, len(order.Identitifers))
-	for i, identity := range order.Identitifers {
-		dnsNames[i] = identity.Value
+	// list validated identifiers
+	dnsNames := make([]string, 0, len(order.Identitifers))
+	ipAddresses := make([]string, 0, len(order.Identitifers))
+	for _, identity := range order.Identitifers {
+		switch identity.Type {
+		case "ip":
+			ipAddresses = append(ipAddresses, identity.Value)
+		default:
+			dnsNames = append(dnsNames, strings.ToLower(identity.Value))
+		}
 	}
 	sort.Strings(dnsNames)
+	sort.Strings(ipAddresses)
 	// check that the common name is a validated identity
-	found := false
-	for _, dnsName := range dnsNames {
-		if dnsName == csr.Subject.CommonName {
+	// ip only orders may omit the common name
+	found := len(csr.Subject.CommonName) == 0 && len(dnsNames) == 0
+	for _, identity := range order.Identitifers {
+		if strings.EqualFold(identity.Value, csr.Subject.CommonName) {
 			found = true
 			break
 		}
@@ -145,26 +267,47 @@ func Post(c *gin.Context) {
 	}
 	// check that dns names are equal to dnsNames
 	csrNames := make([]string, len(csr.DNSNames))
-	copy(csrNames, csr.DNSNames)
-	if len(csrNames) != len(dnsNames) {
-		log.Errorf("Alternative names does not match identities count")
+	for i, name := range csr.DNSNames {
+		csrNames[i] = strings.ToLower(name)
+	}
+	if !sameNames(csrNames, dnsNames) {
+		log.Errorf("Alternative names does not match identities (%s)", strings.Join(csrNames, ", "))
 		problem.BadCSR(c)
 		return
 	}
-	sort.Strings(csrNames)
-	for i := 0; i < len(csrNames); i++ {
-		if csrNames[i] != dnsNames[i] {
-			log.Errorf("Alternative names does not match identities ('%s')", csrNames[i])
-			problem.BadCSR(c)
-			return
-		}
+	// check that ip addresses are equal to ipAddresses
+	csrIPs := make([]string, len(csr.IPAddresses))
+	for i, ip := range csr.IPAddresses {
+		csrIPs[i] = ip.String()
+	}
+	if !sameNames(csrIPs, ipAddresses) {
+		log.Errorf("IP addresses does not match identities (%s)", strings.Join(csrIPs, ", "))
+		problem.BadCSR(c)
+		return
 	}
 	// check that no other types of alternative names are provided
-	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 {
-		log.Errorf("Alternative names contains mails or IPs")
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		log.Errorf("Alternative names contains mails or URIs")
 		problem.BadCSR(c)
 		return
 	}
+	// check certificates per registered domain
+	domains := map[string]bool{}
+	for _, identity := range order.Identitifers {
+		domains[ratelimit.RegisteredDomain(identity.Value)] = true
+	}
+	for domain := range domains {
+		retry, err := ratelimit.Check(store, ratelimit.CertificatesPerDomain, domain)
+		if err != nil {
+			log.Errorf("cannot check rate limit for %s: %s", domain, err)
+			problem.ServerInternal(c)
+			return
+		}
+		if retry > 0 {
+			ratelimit.Limited(c, ratelimit.CertificatesPerDomain, domain, retry)
+			return
+		}
+	}
 	// call ca to issue certificate
 	caurl, capass, err := ca.GetInfo(c)
 	if err != nil {
@@ -176,6 +319,17 @@ func Post(c *gin.Context) {
 	csrtxt := base64.StdEncoding.EncodeToString(csr.Raw)
 	// path to csr to the ca
 	url = fmt.Sprintf("%s%s", caurl, ep.CsrPath)
+	// issuing account, order and requested validity
+	params := neturl.Values{}
+	params.Set(AccountParam, order.KeyID)
+	params.Set(OrderParam, order.ID)
+	if order.NotBefore != nil {
+		params.Set(NotBeforeParam, order.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if order.NotAfter != nil {
+		params.Set(NotAfterParam, order.NotAfter.UTC().Format(time.RFC3339))
+	}
+	url = fmt.Sprintf("%s?%s", url, params.Encode())
 	// authentication
 	auth := fmt.Sprintf("Bearer %s", base64.RawURLEncoding.EncodeToString([]byte(capass)))
 	// create the request
@@ -192,6 +346,7 @@ func Post(c *gin.Context) {
 		c.JSON(http.StatusOK, order)
 		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusCreated {
 		log.Errorf("ca server didn't create certificate")
 		c.JSON(http.StatusOK, order)
@@ -203,17 +358,47 @@ func Post(c *gin.Context) {
 		c.JSON(http.StatusOK, order)
 		return
 	}
+	// the ca returns the issued certificate
+	// its validity may be shorter than requested when limited by the ca expiry
+	rawcert, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("cannot read issued certificate: %s", err)
+		c.JSON(http.StatusOK, order)
+		return
+	}
+	issued, err := x509.ParseCertificate(rawcert)
+	if err != nil {
+		log.Errorf("cannot parse issued certificate: %s", err)
+		c.JSON(http.StatusOK, order)
+		return
+	}
 	// set path to cert
 	certurl := fmt.Sprintf("%s%s/%s", location.Get(c).String(), ep.CertPath, certid)
 	// set certificate url in order
 	order.Certificate = certurl
 	// set order as valid
 	order.Status = "valid"
+	order.CertificateExpires = &issued.NotAfter
 	store.UpdateOrder(order)
 	log.Infof("order %s valid: %s", order.ID, order.Certificate)
+	for domain := range domains {
+		err = ratelimit.Record(store, ratelimit.CertificatesPerDomain, domain)
+		if err != nil {
+			log.Errorf("cannot record certificate for %s: %s", domain, err)
+		}
+	}
 	c.JSON(http.StatusOK, order)
 }
 
+// KeyUsage returns the key usages of a certificate for a public key
+// key encipherment only applies to RSA keys
+func KeyUsage(pub interface{}) x509.KeyUsage {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
 // CaPost handles a post request to get a certificate from the CA
 func CaPost(c *gin.Context) {
 	// get signing informations
@@ -231,6 +416,13 @@ func CaPost(c *gin.Context) {
 		return
 	}
 	rootcert := store.GetCA()
+	// get publication informations
+	crlurl, ocspurl, err := ca.GetPublication(c)
+	if err != nil {
+		log.Errorf("could not get publication infos: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
 	// read request body
 	rawcsr, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
@@ -240,6 +432,11 @@ func CaPost(c *gin.Context) {
 	}
 	// decode csr
 	csrBytes, err := base64.StdEncoding.DecodeString(string(rawcsr))
+	if err != nil {
+		log.Errorf("could not decode csr base64: %s", err)
+		problem.Malformed(c)
+		return
+	}
 	// parse request
 	csr, err := x509.ParseCertificateRequest(csrBytes)
 	if err != nil {
@@ -247,6 +444,37 @@ func CaPost(c *gin.Context) {
 		problem.ServerInternal(c)
 		return
 	}
+	// requested validity
+	notBefore, err := queryTime(c, NotBeforeParam)
+	if err != nil {
+		log.Errorf("could not parse requested validity: %s", err)
+		problem.Send(c, problem.NewMalformed(err.Error()))
+		return
+	}
+	notAfter, err := queryTime(c, NotAfterParam)
+	if err != nil {
+		log.Errorf("could not parse requested validity: %s", err)
+		problem.Send(c, problem.NewMalformed(err.Error()))
+		return
+	}
+	// the requested validity was checked when the order was created
+	start, end := Validity(notBefore, notAfter)
+	err = CheckLifetime(start, end)
+	if err != nil {
+		log.Errorf("requested validity out of policy: %s", err)
+		problem.Send(c, problem.NewMalformed(err.Error()))
+		return
+	}
+	// certificates cannot outlive the ca
+	if end.After(rootcert.NotAfter) {
+		log.Warnf("validity of certificate limited to the ca expiry: %s", rootcert.NotAfter)
+		end = rootcert.NotAfter
+	}
+	if !end.After(start) {
+		log.Errorf("ca certificate expired on %s", rootcert.NotAfter)
+		problem.ServerInternal(c)
+		return
+	}
 	// generate serial
 	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 8*20))
 	if err != nil {
@@ -255,23 +483,25 @@ func CaPost(c *gin.Context) {
 		return
 	}
 	// create client certificate template
+	// the signature algorithm is picked from the ca key
 	template := x509.Certificate{
-		Signature:          csr.Signature,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
-
-		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
-		PublicKey:          csr.PublicKey,
-
 		SerialNumber:          serial,
 		Issuer:                rootcert.Subject,
 		Subject:               csr.Subject,
 		DNSNames:              csr.DNSNames,
-		NotBefore:             time.Now().Add(-1 * time.Hour),
-		NotAfter:              time.Now().Add(ValidityPeriod),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		IPAddresses:           csr.IPAddresses,
+		NotBefore:             start,
+		NotAfter:              end,
+		KeyUsage:              KeyUsage(csr.PublicKey),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
+	if len(crlurl) > 0 {
+		template.CRLDistributionPoints = []string{crlurl}
+	}
+	if len(ocspurl) > 0 {
+		template.OCSPServer = []string{ocspurl}
+	}
 	// create client certificate from template and CA public key
 	clientcert, err := x509.CreateCertificate(rand.Reader, &template, rootcert, csr.PublicKey, rootkey)
 	if err != nil {
@@ -279,7 +509,12 @@ func CaPost(c *gin.Context) {
 		problem.ServerInternal(c)
 		return
 	}
-	store.AddCert(&clientcert)
+	err = store.AddCert(&clientcert, c.Query(AccountParam), c.Query(OrderParam))
+	if err != nil {
+		log.Errorf("could not store certificate: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
 	// get certificate hash
 	crt, err := x509.ParseCertificate(clientcert)
 	if err != nil {
@@ -287,11 +522,10 @@ func CaPost(c *gin.Context) {
 		problem.ServerInternal(c)
 		return
 	}
-	hash := md5.Sum(crt.Raw)
-	sign := base64.RawURLEncoding.EncodeToString(hash[:])
+	sign := utils.ID(crt.Raw)
 	url := location.Get(c).String()
 	log.Infof("Generated %s cert for %s", sign, crt.Subject.CommonName)
 	c.Header("Location", fmt.Sprintf("%sca/%s/%s", url, ep.CertPath, sign))
 	c.Header("ETag", sign)
-	c.Status(http.StatusCreated)
+	c.Data(http.StatusCreated, CertType, crt.Raw)
 }
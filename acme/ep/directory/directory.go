@@ -4,17 +4,25 @@ import (
 	"net/http"
 
 	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/ep/eab"
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 )
 
+// Meta represents the metadata of the ACME directory
+type Meta struct {
+	ExternalAccountRequired bool `json:"externalAccountRequired,omitempty"`
+}
+
 // Directory represents the ACME directory
 type Directory struct {
 	NewNonce   string `json:"newNonce"`
 	NewAccount string `json:"newAccount"`
 	NewOrder   string `json:"newOrder"`
+	NewAuthz   string `json:"newAuthz"`
 	RevokeCert string `json:"revokeCert"`
 	KeyChange  string `json:"keyChange"`
+	Meta       *Meta  `json:"meta,omitempty"`
 }
 
 // Get handles get request to directory
@@ -24,8 +32,12 @@ func Get(c *gin.Context) {
 		NewNonce:   url + ep.NoncePath,
 		NewAccount: url + ep.AccountPath,
 		NewOrder:   url + ep.OrderPath,
+		NewAuthz:   url + ep.AuthzPath,
 		RevokeCert: url + ep.RevokePath,
 		KeyChange:  url + ep.KeyPath,
 	}
+	if eab.Required {
+		dir.Meta = &Meta{ExternalAccountRequired: true}
+	}
 	c.JSON(http.StatusOK, dir)
 }
@@ -0,0 +1,182 @@
+package eab
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/middlewares/objectstore"
+	acmestore "github.com/cblomart/ACMECA/objectstore"
+	"github.com/cblomart/ACMECA/objectstore/objects"
+	"github.com/cblomart/ACMECA/objectstore/utils"
+	"github.com/gin-gonic/gin"
+	jose "gopkg.in/square/go-jose.v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// KeyLength is the length of generated MAC keys
+	KeyLength = 32
+)
+
+var (
+	// Required indicates if new accounts must be bound to an external account
+	Required = false
+)
+
+// Req is the request to create an external account key
+type Req struct {
+	Description string `json:"description"`
+}
+
+// Verify verifies an external account binding (RFC 8555 §7.3.4)
+// and returns the id of the external account key
+func Verify(store acmestore.ObjectStore, binding []byte, accountKey string, url string) (string, error) {
+	jws, err := jose.ParseSigned(string(binding))
+	if err != nil {
+		return "", fmt.Errorf("cannot decode binding: %s", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return "", fmt.Errorf("binding should have exactly one signature")
+	}
+	protected := jws.Signatures[0].Protected
+	if !strings.HasPrefix(protected.Algorithm, "HS") {
+		return "", fmt.Errorf("binding must be signed with a MAC algorithm: %s", protected.Algorithm)
+	}
+	if len(protected.Nonce) > 0 {
+		return "", fmt.Errorf("binding must not contain a nonce")
+	}
+	if bindingURL, ok := protected.ExtraHeaders["url"]; !ok || fmt.Sprintf("%s", bindingURL) != url {
+		return "", fmt.Errorf("binding url mismatch request url")
+	}
+	// get the mac key
+	key, err := store.GetExternalAccountKey(protected.KeyID)
+	if err != nil {
+		return "", fmt.Errorf("cannot retrieve external account key %s: %s", protected.KeyID, err)
+	}
+	if key == nil {
+		return "", fmt.Errorf("unknown external account key %s", protected.KeyID)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(key.Key)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode external account key %s: %s", key.ID, err)
+	}
+	payload, err := jws.Verify(mac)
+	if err != nil {
+		return "", fmt.Errorf("cannot verify binding with %s: %s", key.ID, err)
+	}
+	// payload is the key of the account
+	var jwk jose.JSONWebKey
+	err = json.Unmarshal(payload, &jwk)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode bound key: %s", err)
+	}
+	rawkey, err := x509.MarshalPKIXPublicKey(jwk.Key)
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize bound key: %s", err)
+	}
+	if base64.RawURLEncoding.EncodeToString(rawkey) != accountKey {
+		return "", fmt.Errorf("bound key is not the account key")
+	}
+	return key.ID, nil
+}
+
+// List lists the external account keys
+func List(c *gin.Context) {
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot retrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	keys, err := store.GetExternalAccountKeys()
+	if err != nil {
+		log.Errorf("cannot list external account keys: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	// mac keys are only returned on creation
+	for i := range keys {
+		keys[i].Key = ""
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// Post creates an external account key
+func Post(c *gin.Context) {
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot retrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read request body: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	req := &Req{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, req)
+		if err != nil {
+			log.Errorf("cannot decode external account key request: %s", err)
+			problem.Malformed(c)
+			return
+		}
+	}
+	// generate the mac key
+	b := make([]byte, KeyLength)
+	_, err = rand.Read(b)
+	if err != nil {
+		log.Errorf("cannot generate mac key: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	key := objects.ExternalAccountKey{
+		ID:          utils.ID(),
+		Key:         base64.RawURLEncoding.EncodeToString(b),
+		Description: req.Description,
+		Created:     time.Now(),
+	}
+	err = store.CreateExternalAccountKey(key)
+	if err != nil {
+		log.Errorf("cannot create external account key: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("created external account key %s: %s", key.ID, key.Description)
+	c.JSON(http.StatusCreated, key)
+}
+
+// Delete removes an external account key
+func Delete(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		log.Errorf("id of the external account key needed")
+		problem.Malformed(c)
+		return
+	}
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot retrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	err = store.DeleteExternalAccountKey(id)
+	if err != nil {
+		log.Errorf("cannot delete external account key %s: %s", id, err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	log.Infof("deleted external account key %s", id)
+	c.Status(http.StatusNoContent)
+}
@@ -11,6 +11,8 @@ const (
 	NoncePath = "/nonce"
 	// OrderPath is the path to order endpoint
 	OrderPath = "/order"
+	// OrdersPath is the path to the orders list of accounts
+	OrdersPath = "/orders"
 	// RevokePath is the path to revoke endpoint
 	RevokePath = "/revoke"
 	// ChallengePath is the path to challenges
@@ -21,8 +23,14 @@ const (
 	CsrPath = "/csr"
 	// CrlPath is the path to the CRL
 	CrlPath = "/crl"
+	// OcspPath is the path to the OCSP responder
+	OcspPath = "/ocsp"
 	// CertPath is the path to public certificate
 	CertPath = "/cert"
 	// HealthPath path
 	HealthPath = "/health"
+	// AdminPath is the path to the administration endpoints
+	AdminPath = "/admin"
+	// EabPath is the path to the external account keys
+	EabPath = "/eab"
 )
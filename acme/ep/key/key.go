@@ -1 +1,75 @@
 package key
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/middlewares/objectstore"
+	"github.com/gin-contrib/location"
+	"github.com/gin-gonic/gin"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Post handles a key change request (RFC 8555 §7.3.5)
+// the inner jws is validated by the DecodeKeyChange middleware
+func Post(c *gin.Context) {
+	var kid string
+	if tmp, ok := c.Get("kid"); ok {
+		kid = fmt.Sprintf("%s", tmp)
+	}
+	var oldkey string
+	if tmp, ok := c.Get("oldkey"); ok {
+		oldkey = fmt.Sprintf("%s", tmp)
+	}
+	var newkey string
+	if tmp, ok := c.Get("newkey"); ok {
+		newkey = fmt.Sprintf("%s", tmp)
+	}
+	if len(kid) == 0 || len(oldkey) == 0 || len(newkey) == 0 {
+		log.Errorf("key change without account or keys")
+		problem.Malformed(c)
+		return
+	}
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot retrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	url := location.Get(c).String()
+	// check that the new key is not used by another account
+	existing, err := store.GetAccountFromKey(newkey)
+	if err != nil {
+		log.Errorf("cannot recover account: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	if existing != nil {
+		log.Errorf("new key already used by account %s", existing.KeyID)
+		c.Header("Location", fmt.Sprintf("%s%s/%s", url, ep.AccountPath, existing.KeyID))
+		p := problem.NewMalformed("the new key is already used by an account")
+		p.Status = http.StatusConflict
+		problem.Send(c, p)
+		return
+	}
+	err = store.ChangeAccountKey(kid, oldkey, newkey)
+	if err != nil {
+		log.Errorf("cannot change key of account %s: %s", kid, err)
+		p := problem.NewMalformed("the key of the account could not be changed")
+		p.Status = http.StatusConflict
+		problem.Send(c, p)
+		return
+	}
+	account, err := store.GetAccount(kid)
+	if err != nil || account == nil {
+		log.Errorf("cannot recover account %s: %s", kid, err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("changed key of account %s", kid)
+	c.Header("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", url, ep.DirectoryPath))
+	c.JSON(http.StatusOK, account)
+}
@@ -0,0 +1,248 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cblomart/ACMECA/middlewares/ca"
+	"github.com/cblomart/ACMECA/middlewares/certstore"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ocsp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Validity is the validity of an OCSP response
+	Validity = time.Hour * 12
+	// RequestType is the content type of OCSP requests
+	RequestType = "application/ocsp-request"
+	// ResponseType is the content type of OCSP responses
+	ResponseType = "application/ocsp-response"
+	// maxRequestSize is the maximum size of an OCSP request
+	maxRequestSize = 4096
+	// maxCacheSize is the maximum number of cached responses
+	maxCacheSize = 10000
+)
+
+// cachedResponse is a signed response kept until its next update
+type cachedResponse struct {
+	der        []byte
+	nextUpdate time.Time
+}
+
+var (
+	cache    = map[string]cachedResponse{}
+	cachemux sync.Mutex
+)
+
+// cacheKey is the key of a response in the cache
+// the issuer hash algorithm of the request is part of the response
+func cacheKey(serial string, hash crypto.Hash) string {
+	return fmt.Sprintf("%s/%d", serial, hash)
+}
+
+// cacheResponse caches a response until its next update
+// expired responses are evicted when the cache is full then any response if needed
+func cacheResponse(key string, resp cachedResponse) {
+	cachemux.Lock()
+	defer cachemux.Unlock()
+	if len(cache) >= maxCacheSize {
+		now := time.Now()
+		for k, cached := range cache {
+			if !now.Before(cached.nextUpdate) {
+				delete(cache, k)
+			}
+		}
+	}
+	for k := range cache {
+		if len(cache) < maxCacheSize {
+			break
+		}
+		delete(cache, k)
+	}
+	cache[key] = resp
+}
+
+// Invalidate removes the cached responses for a serial
+func Invalidate(serial string) {
+	cachemux.Lock()
+	defer cachemux.Unlock()
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		delete(cache, cacheKey(serial, hash))
+	}
+}
+
+// Get handles OCSP requests encoded in the url (RFC 6960 Appendix A.1)
+func Get(c *gin.Context) {
+	// request may be url encoded and contain slashes
+	raw := strings.TrimPrefix(c.Param("request"), "/")
+	raw, err := url.PathUnescape(raw)
+	if err != nil {
+		log.Errorf("cannot unescape ocsp request: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		log.Errorf("cannot decode ocsp request: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	respond(c, der)
+}
+
+// Post handles OCSP requests in the body
+func Post(c *gin.Context) {
+	der, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestSize))
+	if err != nil {
+		log.Errorf("cannot read ocsp request: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	respond(c, der)
+}
+
+// issuerKeyHash hashes the public key of the issuer as in OCSP cert ids
+func issuerKeyHash(issuer *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash algorithm %v not available", hash)
+	}
+	h := hash.New()
+	h.Write(publicKeyInfo.PublicKey.RightAlign())
+	return h.Sum(nil), nil
+}
+
+// respond answers an OCSP request
+func respond(c *gin.Context, der []byte) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		log.Errorf("cannot parse ocsp request: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	serial := req.SerialNumber.String()
+	// get certificate store
+	store, err := certstore.Get(c)
+	if err != nil {
+		log.Errorf("could not get certificate store: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	issuer := store.GetCA()
+	// check that the request is for this CA
+	keyHash, err := issuerKeyHash(issuer, req.HashAlgorithm)
+	if err != nil {
+		log.Errorf("cannot hash issuer key: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	if !bytes.Equal(keyHash, req.IssuerKeyHash) {
+		log.Errorf("ocsp request for serial %s of another issuer", serial)
+		c.Data(http.StatusOK, ResponseType, ocsp.UnauthorizedErrorResponse)
+		return
+	}
+	// check cache
+	key := cacheKey(serial, req.HashAlgorithm)
+	cachemux.Lock()
+	cached, ok := cache[key]
+	if ok && !time.Now().Before(cached.nextUpdate) {
+		delete(cache, key)
+		ok = false
+	}
+	cachemux.Unlock()
+	if ok {
+		send(c, cached)
+		return
+	}
+	// get signing informations
+	responderCert, responderKey, err := ca.GetResponder(c)
+	if err != nil {
+		log.Errorf("could not get responder infos: %s", err)
+		c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	signer, ok := responderKey.(crypto.Signer)
+	if !ok {
+		log.Errorf("responder key cannot sign")
+		c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: new(big.Int).Set(req.SerialNumber),
+		IssuerHash:   req.HashAlgorithm,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(Validity),
+	}
+	// delegated responders must include their certificate
+	if !responderCert.Equal(issuer) {
+		template.Certificate = responderCert
+	}
+	// lookup certificate status
+	id, err := store.GetCertID(serial)
+	if err != nil {
+		log.Errorf("could not lookup serial %s: %s", serial, err)
+		c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	if len(id) == 0 {
+		template.Status = ocsp.Unknown
+	} else {
+		revocation, err := store.GetRevocation(id)
+		if err != nil {
+			log.Errorf("could not get revocation of %s: %s", id, err)
+			c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+			return
+		}
+		if revocation != nil {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = revocation.Revoked
+			template.RevocationReason = revocation.Reason
+		}
+	}
+	resp, err := ocsp.CreateResponse(issuer, responderCert, template, signer)
+	if err != nil {
+		log.Errorf("could not create ocsp response for %s: %s", serial, err)
+		c.Data(http.StatusOK, ResponseType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	cached = cachedResponse{der: resp, nextUpdate: template.NextUpdate}
+	// unknown serials are not cached as anyone can request them
+	if template.Status != ocsp.Unknown {
+		cacheResponse(key, cached)
+	}
+	log.Infof("ocsp response for serial %s: %d", serial, template.Status)
+	send(c, cached)
+}
+
+// send writes a signed response, cacheable until its next update
+func send(c *gin.Context, resp cachedResponse) {
+	if c.Request.Method == http.MethodGet {
+		maxAge := int(time.Until(resp.nextUpdate).Seconds())
+		c.Header("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+		c.Header("Expires", resp.nextUpdate.UTC().Format(http.TimeFormat))
+	}
+	c.Data(http.StatusOK, ResponseType, resp.der)
+}
@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/ep/csr"
+	"github.com/cblomart/ACMECA/acme/policy"
 	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/acme/ratelimit"
 	"github.com/cblomart/ACMECA/middlewares/objectstore"
 	"github.com/cblomart/ACMECA/objectstore/objects"
 	"github.com/cblomart/ACMECA/objectstore/utils"
@@ -18,10 +21,23 @@ import (
 )
 
 const (
-	// DefaultDurationMinutes is the time, in minutes, an order is valid
+	// DefaultDurationMinutes is the time, in minutes, a client has to validate and finalize an order
 	DefaultDurationMinutes = 5
+	// PageSize is the number of orders in a page of the orders list
+	PageSize = 100
+	// CursorParam is the query parameter of the orders list cursor
+	CursorParam = "cursor"
 )
 
+// Lifetime is the time an order is valid
+// it is extended by the validation window so that validations end before the order expires
+var Lifetime = time.Minute * DefaultDurationMinutes
+
+// List is the list of orders of an account (RFC 8555 §7.1.2.1)
+type List struct {
+	Orders []string `json:"orders"`
+}
+
 // Post handles a post request to order enpoint
 func Post(c *gin.Context) {
 	// get the use key id
@@ -46,7 +62,7 @@ func Post(c *gin.Context) {
 	id := strings.Trim(c.Param("id"), "/")
 	if len(id) > 0 {
 		// post as get
-		order, err := store.GetOrder(id, fmt.Sprintf("%s/%s", url, ep.AuthzPath))
+		order, err := store.GetOrder(id, fmt.Sprintf("%s%s", url, ep.AuthzPath))
 		if err != nil {
 			log.Errorf("cannot retrieve order: %s", err)
 			problem.ServerInternal(c)
@@ -60,20 +76,7 @@ func Post(c *gin.Context) {
 			c.JSON(http.StatusOK, order)
 			return
 		}
-		orders, err := store.GetOrderByAccount(id)
-		if err != nil {
-			log.Errorf("cannot retrieve store: %s", err)
-			problem.ServerInternal(c)
-			return
-		}
-		if orders != nil {
-			// send the response
-			log.Infof("returning order from account")
-			c.Header("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", url, ep.DirectoryPath))
-			c.JSON(http.StatusOK, orders)
-			return
-		}
-		log.Info("no order found for user or order %s", id)
+		log.Infof("no order found with id %s", id)
 		c.Status(http.StatusNotFound)
 		return
 	}
@@ -87,21 +90,81 @@ func Post(c *gin.Context) {
 		problem.Malformed(c)
 		return
 	}
-	order := &objects.Order{}
-	err = json.Unmarshal([]byte(payload), order)
+	req := &objects.Order{}
+	err = json.Unmarshal([]byte(payload), req)
 	if err != nil {
 		log.Errorf("cannot unmarshal order")
 		problem.Malformed(c)
 		return
 	}
+	// only the identifiers and the requested validity come from the client
+	// authorizations, status, finalize and certificate are set by the server
+	order := &objects.Order{
+		Identitifers: req.Identitifers,
+		NotBefore:    req.NotBefore,
+		NotAfter:     req.NotAfter,
+	}
+	// check requested validity
+	err = csr.CheckValidity(order.NotBefore, order.NotAfter)
+	if err != nil {
+		log.Errorf("requested validity out of policy: %s", err)
+		problem.Send(c, problem.NewMalformed(err.Error()))
+		return
+	}
 	order.ID = utils.ID()
 	order.KeyID = kid
 	log.Infof("recieved order %s from %s: %s", order.ID, order.KeyID, payload)
+	// check identifiers
+	rejected, unsupported := order.CheckOrder()
+	if unsupported != nil {
+		log.Errorf("unsupported order: %s", unsupported)
+		problem.UnsupportedIdentifier(c)
+		return
+	}
+	if rejected != nil {
+		log.Errorf("rejected order: %s", rejected)
+		problem.RejectedIdentifier(c)
+		return
+	}
+	// check issuance policy
+	account, err := store.GetAccount(kid)
+	if err != nil || account == nil {
+		log.Errorf("cannot retrieve account %s: %s", kid, err)
+		problem.AccountDoesNotExist(c)
+		return
+	}
+	ids := make([]string, len(order.Identitifers))
+	for i, id := range order.Identitifers {
+		ids[i] = id.String()
+	}
+	if denial := policy.Check(kid, account.ExternalAccountID, ids); denial != nil {
+		log.Errorf("order %s denied by policy: %s", order.ID, denial)
+		problem.Send(c, problem.NewRejectedIdentifier(denial.Detail))
+		return
+	}
+	// check rate limits
+	for _, id := range order.Identitifers {
+		hostname, _ := id.Base()
+		hostname = strings.ToLower(hostname)
+		retry, err := ratelimit.Check(store, ratelimit.FailedValidationsPerHostname, hostname)
+		if err != nil {
+			log.Errorf("cannot check rate limit for %s: %s", hostname, err)
+			problem.ServerInternal(c)
+			return
+		}
+		if retry > 0 {
+			ratelimit.Limited(c, ratelimit.FailedValidationsPerHostname, hostname, retry)
+			return
+		}
+	}
+	if !ratelimit.Enforce(c, store, ratelimit.NewOrdersPerAccount, kid) {
+		return
+	}
 	// set basic properties
 	order.Status = "pending"
-	expires := time.Now().Add(time.Minute * DefaultDurationMinutes)
+	expires := time.Now().Add(Lifetime)
 	order.Expires = &expires
-	rejected, unsupported, err := store.CreateOrder(order, fmt.Sprintf("%s%s", url, ep.AuthzPath), fmt.Sprintf("%s%s", url, ep.ChallengePath), fmt.Sprintf("%s%s", url, ep.CsrPath))
+	rejected, unsupported, err = store.CreateOrder(order, fmt.Sprintf("%s%s", url, ep.AuthzPath), fmt.Sprintf("%s%s", url, ep.ChallengePath), fmt.Sprintf("%s%s", url, ep.CsrPath))
 	if unsupported != nil {
 		log.Errorf("unsupported order: %s", unsupported)
 		problem.UnsupportedIdentifier(c)
@@ -125,3 +188,46 @@ func Post(c *gin.Context) {
 	c.JSON(http.StatusCreated, order)
 	return
 }
+
+// ListPost handles a post request to the orders list of an account
+// the list is paginated with a cursor passed in the next link
+func ListPost(c *gin.Context) {
+	// get the use key id
+	var kid string
+	if tmp, ok := c.Get("kid"); ok {
+		kid = fmt.Sprintf("%s", tmp)
+	}
+	id := strings.Trim(c.Param("id"), "/")
+	// orders can only be listed by their account
+	if len(kid) == 0 || kid != id {
+		log.Errorf("orders of %s requested by %s", id, kid)
+		problem.Unauthorized(c)
+		return
+	}
+	url := location.Get(c).String()
+	store, err := objectstore.Get(c)
+	if err != nil {
+		log.Errorf("cannot rretrieve store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	cursor := c.Query(CursorParam)
+	// get one more order to know if there is a next page
+	ids, err := store.ListOrders(kid, cursor, PageSize+1)
+	if err != nil {
+		log.Errorf("cannot list orders: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	list := List{Orders: make([]string, 0, PageSize)}
+	for i, orderID := range ids {
+		if i == PageSize {
+			next := fmt.Sprintf("%s%s/%s?%s=%s", url, ep.OrdersPath, kid, CursorParam, ids[i-1])
+			c.Writer.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"next\"", next))
+			break
+		}
+		list.Orders = append(list.Orders, fmt.Sprintf("%s%s/%s", url, ep.OrderPath, orderID))
+	}
+	log.Infof("returning %d orders of %s", len(list.Orders), kid)
+	c.JSON(http.StatusOK, list)
+}
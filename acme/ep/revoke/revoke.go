@@ -1 +1,258 @@
 package revoke
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/ep/crl"
+	"github.com/cblomart/ACMECA/acme/ep/ocsp"
+	"github.com/cblomart/ACMECA/acme/problem"
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/certstore/utils"
+	"github.com/cblomart/ACMECA/middlewares/ca"
+	"github.com/cblomart/ACMECA/middlewares/certstore"
+	"github.com/gin-gonic/gin"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var client = http.Client{}
+
+// Payload represents the payload of a revocation request
+type Payload struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason,omitempty"`
+}
+
+// CaPayload represents the revocation request sent to the CA
+type CaPayload struct {
+	Reason int `json:"reason"`
+	// Account must be the issuing account of the certificate when set
+	Account string `json:"account,omitempty"`
+}
+
+// Post handles a post request to the revoke endpoint
+func Post(c *gin.Context) {
+	// get information from jws
+	var payload string
+	if tmp, ok := c.Get("payload"); ok {
+		payload = fmt.Sprintf("%s", tmp)
+	}
+	var kid string
+	if tmp, ok := c.Get("kid"); ok {
+		kid = fmt.Sprintf("%s", tmp)
+	}
+	var key string
+	if tmp, ok := c.Get("key"); ok {
+		key = fmt.Sprintf("%s", tmp)
+	}
+	if len(payload) == 0 {
+		log.Errorf("revocation called without a payload")
+		problem.Malformed(c)
+		return
+	}
+	revokeReq := &Payload{}
+	err := json.Unmarshal([]byte(payload), revokeReq)
+	if err != nil {
+		log.Errorf("cannot read revocation payload: %s", err)
+		problem.Malformed(c)
+		return
+	}
+	// decode certificate
+	b, err := base64.RawURLEncoding.DecodeString(revokeReq.Certificate)
+	if err != nil {
+		log.Errorf("cannot read certificate base64: %s", err)
+		problem.Malformed(c)
+		return
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		log.Errorf("cannot decode certificate: %s", err)
+		problem.Malformed(c)
+		return
+	}
+	// check reason
+	reason := objects.ReasonUnspecified
+	if revokeReq.Reason != nil {
+		reason = *revokeReq.Reason
+	}
+	if !objects.ValidReason(reason) {
+		log.Errorf("unsupported revocation reason: %d", reason)
+		problem.BadRevocationReason(c)
+		return
+	}
+	id := utils.ID(cert.Raw)
+	// check authorization
+	caReq := CaPayload{Reason: reason}
+	switch {
+	case len(kid) > 0:
+		// the ca checks that the account is the one the certificate was issued to
+		caReq.Account = kid
+	case len(key) > 0:
+		// request must be signed by the certificate key
+		rawkey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			log.Errorf("cannot serialize certificate key: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		if base64.RawURLEncoding.EncodeToString(rawkey) != key {
+			log.Errorf("revocation of %s not signed by the certificate key", id)
+			problem.Unauthorized(c)
+			return
+		}
+	default:
+		log.Errorf("revocation request without kid nor key")
+		problem.Unauthorized(c)
+		return
+	}
+	// call ca to revoke the certificate
+	caurl, capass, err := ca.GetInfo(c)
+	if err != nil {
+		log.Errorf("cannot find link to CA: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	body, err := json.Marshal(caReq)
+	if err != nil {
+		log.Errorf("cannot serialize revocation request: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	// path to revocation on the ca
+	url := fmt.Sprintf("%s%s/%s", caurl, ep.RevokePath, id)
+	// authentication
+	auth := fmt.Sprintf("Bearer %s", base64.RawURLEncoding.EncodeToString([]byte(capass)))
+	// create the request
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Cannot create request: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	req.Header.Add("Authorization", auth)
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("error to revocation request: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		log.Infof("certificate %s revoked (reason %d)", id, reason)
+		c.Status(http.StatusOK)
+	case http.StatusConflict:
+		log.Errorf("certificate %s already revoked", id)
+		problem.AlreadyRevoked(c)
+	case http.StatusNotFound:
+		log.Errorf("certificate %s unknown to the ca", id)
+		problem.Malformed(c)
+	case http.StatusForbidden:
+		log.Errorf("account %s was not issued certificate %s", kid, id)
+		problem.Unauthorized(c)
+	default:
+		log.Errorf("revocation request returned: %s", resp.Status)
+		problem.ServerInternal(c)
+	}
+}
+
+// CaPost handles a revocation request on the CA
+func CaPost(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) == 0 {
+		log.Errorf("id of the cert needed")
+		problem.Malformed(c)
+		return
+	}
+	// get certificate store
+	store, err := certstore.Get(c)
+	if err != nil {
+		log.Errorf("could not get certificate store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	// read request body
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read request body: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	revokeReq := &CaPayload{}
+	err = json.Unmarshal(body, revokeReq)
+	if err != nil {
+		log.Errorf("could not decode revocation request: %s", err)
+		problem.Malformed(c)
+		return
+	}
+	if !objects.ValidReason(revokeReq.Reason) {
+		log.Errorf("unsupported revocation reason: %d", revokeReq.Reason)
+		problem.BadRevocationReason(c)
+		return
+	}
+	raw, err := store.GetCert(id)
+	if err != nil {
+		log.Errorf("could not get certificate from store: %s", err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	cert, err := x509.ParseCertificate(*raw)
+	if err != nil {
+		log.Errorf("could not parse certificate %s: %s", id, err)
+		problem.ServerInternal(c)
+		return
+	}
+	// account revocations are limited to the certificates issued to the account
+	if len(revokeReq.Account) > 0 {
+		certs, err := store.ListCerts(objects.Filter{Serial: cert.SerialNumber.String(), Account: revokeReq.Account, Limit: 1})
+		if err != nil {
+			log.Errorf("could not get certificate metadata from store: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		if len(certs) == 0 {
+			log.Errorf("certificate %s was not issued to %s", id, revokeReq.Account)
+			c.Status(http.StatusForbidden)
+			return
+		}
+	}
+	revocation, err := store.GetRevocation(id)
+	if err != nil {
+		log.Errorf("could not get revocation from store: %s", err)
+		problem.ServerInternal(c)
+		return
+	}
+	if revocation != nil {
+		log.Errorf("certificate %s already revoked on %s", id, revocation.Revoked)
+		c.Status(http.StatusConflict)
+		return
+	}
+	err = store.RevokeCert(id, revokeReq.Reason)
+	if err != nil {
+		log.Errorf("could not revoke certificate %s: %s", id, err)
+		problem.ServerInternal(c)
+		return
+	}
+	log.Infof("revoked certificate %s (reason %d)", id, revokeReq.Reason)
+	// drop cached ocsp response
+	ocsp.Invalidate(cert.SerialNumber.String())
+	// publish the revocation in the crl
+	key, err := ca.GetSigning(c)
+	if err != nil {
+		log.Errorf("could not get signing infos: %s", err)
+	} else {
+		err = crl.Generate(store, key)
+		if err != nil {
+			log.Errorf("cannot generate crl: %s", err)
+		}
+	}
+	c.Status(http.StatusOK)
+}
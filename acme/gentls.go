@@ -2,7 +2,10 @@ package acme
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -19,41 +22,90 @@ import (
 	"time"
 
 	"github.com/cblomart/ACMECA/acme/ep"
+	"github.com/cblomart/ACMECA/acme/ep/csr"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	keySize = 4096
+	// KeyRSA2048 is a 2048 bits RSA key
+	KeyRSA2048 = "rsa2048"
+	// KeyRSA3072 is a 3072 bits RSA key
+	KeyRSA3072 = "rsa3072"
+	// KeyRSA4096 is a 4096 bits RSA key
+	KeyRSA4096 = "rsa4096"
+	// KeyP256 is an ECDSA key on the P-256 curve
+	KeyP256 = "p256"
+	// KeyP384 is an ECDSA key on the P-384 curve
+	KeyP384 = "p384"
+	// KeyEd25519 is an Ed25519 key
+	KeyEd25519 = "ed25519"
 )
 
+// generateKey generates a key of the requested algorithm
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch strings.ToLower(algorithm) {
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %s", algorithm)
+	}
+}
+
+// checkKeyAlgorithm checks that a key algorithm is supported
+func checkKeyAlgorithm(algorithm string) error {
+	switch strings.ToLower(algorithm) {
+	case KeyRSA2048, KeyRSA3072, KeyRSA4096, KeyP256, KeyP384, KeyEd25519:
+		return nil
+	default:
+		return fmt.Errorf("unknown key algorithm %s (rsa2048, rsa3072, rsa4096, p256, p384 or ed25519)", algorithm)
+	}
+}
+
 func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
 		return &k.PublicKey
 	case *ecdsa.PrivateKey:
 		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
 	default:
 		return nil
 	}
 }
 
+// pemBlockForKey encodes keys in PKCS#8
 func pemBlockForKey(priv interface{}) (*pem.Block, error) {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
-	case *ecdsa.PrivateKey:
-		b, err := x509.MarshalECPrivateKey(k)
+	switch priv.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		b, err := x509.MarshalPKCS8PrivateKey(priv)
 		if err != nil {
 			return nil, err
 		}
-		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}, nil
 	default:
 		return nil, fmt.Errorf("Unknown private key format")
 	}
 }
 
 func keyForPemBlock(pem *pem.Block) (interface{}, error) {
+	if pem == nil {
+		return nil, fmt.Errorf("no pem block found")
+	}
 	switch pem.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(pem.Bytes)
 	case "RSA PRIVATE KEY":
 		return x509.ParsePKCS1PrivateKey(pem.Bytes)
 	case "EC PRIVATE KEY":
@@ -77,6 +129,85 @@ func readCert(certfile string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// readChain reads the certificates of a chain from a pem file
+func readChain(chainfile string) ([]*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(chainfile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read chain: %s", err)
+	}
+	chain := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse chain certificate: %s", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in chain %s", chainfile)
+	}
+	return chain, nil
+}
+
+// checkChain checks the chain of the ca (ca first, then its issuers) against its key
+// each certificate must be signed by the next one, the self signed root is
+// only kept if requested
+func checkChain(chain []*x509.Certificate, key interface{}, root bool) ([]*x509.Certificate, error) {
+	ca := chain[0]
+	pub, err := x509.MarshalPKIXPublicKey(publicKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal ca public key: %s", err)
+	}
+	if !bytes.Equal(pub, ca.RawSubjectPublicKeyInfo) {
+		return nil, fmt.Errorf("ca certificate does not match the ca key")
+	}
+	if !ca.IsCA {
+		return nil, fmt.Errorf("ca certificate is not a certificate authority")
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		err := chain[i].CheckSignatureFrom(chain[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s is not issued by %s: %s", chain[i].Subject, chain[i+1].Subject, err)
+		}
+	}
+	last := chain[len(chain)-1]
+	if len(chain) > 1 && !root && last.CheckSignatureFrom(last) == nil {
+		chain = chain[:len(chain)-1]
+	}
+	return chain, nil
+}
+
+// readChains reads the alternate chains of the ca (comma separated files)
+// the first certificate of each chain must have the subject and key of the ca
+// the default chain comes first
+func readChains(chainfiles string, issuers []*x509.Certificate) ([][]*x509.Certificate, error) {
+	ca := issuers[0]
+	chains := [][]*x509.Certificate{issuers}
+	for _, f := range strings.Split(chainfiles, ",") {
+		f = strings.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		chain, err := readChain(f)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(chain[0].RawSubject, ca.RawSubject) || !bytes.Equal(chain[0].RawSubjectPublicKeyInfo, ca.RawSubjectPublicKeyInfo) {
+			return nil, fmt.Errorf("chain %s does not start with a certificate of the ca", f)
+		}
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
 func readKey(keyfile string) (interface{}, error) {
 	// read parent key
 	b, err := ioutil.ReadFile(keyfile)
@@ -91,7 +222,7 @@ func readKey(keyfile string) (interface{}, error) {
 	return key, nil
 }
 
-func writeKey(file string, key *rsa.PrivateKey) {
+func writeKey(file string, key crypto.Signer) {
 	out := &bytes.Buffer{}
 	// encode key
 	pemBlock, err := pemBlockForKey(key)
@@ -110,9 +241,47 @@ func writeKey(file string, key *rsa.PrivateKey) {
 	f.Close()
 }
 
-func generatetls(httpscert, httpskey, hostnames, parentcert, parentkey string, ca bool) {
-	key, err := rsa.GenerateKey(rand.Reader, keySize)
-	//priv, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+// generatecsr generates the request of an intermediate ca to be signed by an offline root
+// an existing key is reused
+func generatecsr(csrfile, keyfile, algorithm string) {
+	var key interface{}
+	var err error
+	if checkFile(keyfile) {
+		key, err = readKey(keyfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		signer, err := generateKey(algorithm)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeKey(keyfile, signer)
+		key = signer
+	}
+	template := x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   "Acme CA Intermediate",
+			Organization: []string{"Acme CA"},
+		},
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		log.Fatalf("Failed to create certificate request: %s", err)
+	}
+	f, err := os.Create(csrfile)
+	if err != nil {
+		log.Fatalf("could not create certificate request file: %s", err)
+	}
+	err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	if err != nil {
+		log.Fatalf("could not write to certificate request file: %s", err)
+	}
+	f.Close()
+}
+
+func generatetls(httpscert, httpskey, hostnames, parentcert, parentkey, algorithm string, ca bool) {
+	key, err := generateKey(algorithm)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -130,7 +299,7 @@ func generatetls(httpscert, httpskey, hostnames, parentcert, parentkey string, c
 		NotBefore: time.Now(),
 		NotAfter:  time.Now().Add(time.Hour * 24 * 30 * 6),
 
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              csr.KeyUsage(key.Public()),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
@@ -141,7 +310,7 @@ func generatetls(httpscert, httpskey, hostnames, parentcert, parentkey string, c
 	if ca {
 		template.IsCA = true
 		template.NotAfter = time.Now().Add(time.Hour * 24 * 30 * 36)
-		template.KeyUsage = template.KeyUsage | x509.KeyUsageCertSign
+		template.KeyUsage = template.KeyUsage | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning)
 	}
 	pub := publicKey(key)
@@ -176,11 +345,18 @@ func generatetls(httpscert, httpskey, hostnames, parentcert, parentkey string, c
 	if err != nil {
 		log.Fatalf("could not write to certificate file: %s", err)
 	}
-	if len(parentcert) == 0 {
+	if parent == &template {
+		f.Close()
 		return
 	}
-	// encode ca certificate
-	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: parent.Raw})
+	// encode ca certificate and its issuers
+	issuers, err := readChain(parentcert)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, issuer := range issuers {
+		pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})
+	}
 	_, err = out.WriteTo(f)
 	if err != nil {
 		log.Fatalf("could not write ca to certificate file: %s", err)
@@ -201,9 +377,8 @@ func waitca(caurl string, wait, count int) {
 	}
 }
 
-func requesttls(httpscert, httpskey, hostnames, caurl, secret string) {
-	key, err := rsa.GenerateKey(rand.Reader, keySize)
-	//priv, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+func requesttls(httpscert, httpskey, hostnames, caurl, secret, algorithm string) {
+	key, err := generateKey(algorithm)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -221,9 +396,8 @@ func requesttls(httpscert, httpskey, hostnames, caurl, secret string) {
 		log.Fatal(err)
 	}
 	template := x509.CertificateRequest{
-		RawSubject:         asn1Subj,
-		DNSNames:           dnsnames,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		RawSubject: asn1Subj,
+		DNSNames:   dnsnames,
 	}
 	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
 	if err != nil {
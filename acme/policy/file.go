@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	current = &Policy{}
+	path    = ""
+	modTime time.Time
+	checked time.Time
+	mux     sync.Mutex
+	// ReloadInterval is the minimum time between two checks of the policy file
+	ReloadInterval = time.Second * 10
+)
+
+// Set sets a static policy
+func Set(p *Policy) {
+	mux.Lock()
+	defer mux.Unlock()
+	current = p
+	path = ""
+}
+
+// Load loads the policy from a json file
+// the file is reloaded when modified
+func Load(file string) error {
+	p, mod, err := read(file)
+	if err != nil {
+		return err
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	current = p
+	path = file
+	modTime = mod
+	checked = time.Now()
+	return nil
+}
+
+// read reads and compiles a policy file
+func read(file string) (*Policy, time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot access policy file: %s", err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot read policy file: %s", err)
+	}
+	p := &Policy{}
+	err = json.Unmarshal(content, p)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot decode policy file: %s", err)
+	}
+	err = p.compile()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid policy file: %s", err)
+	}
+	return p, info.ModTime(), nil
+}
+
+// reload reloads the policy file if it changed
+// an invalid file keeps the previous policy
+func reload() {
+	if len(path) == 0 || time.Since(checked) < ReloadInterval {
+		return
+	}
+	checked = time.Now()
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Errorf("cannot access policy file, keeping current policy: %s", err)
+		return
+	}
+	if info.ModTime().Equal(modTime) {
+		return
+	}
+	p, mod, err := read(path)
+	if err != nil {
+		log.Errorf("keeping current policy: %s", err)
+		modTime = info.ModTime()
+		return
+	}
+	current = p
+	modTime = mod
+	log.Infof("reloaded policy from %s", path)
+}
+
+// Get returns the current policy
+func Get() *Policy {
+	mux.Lock()
+	defer mux.Unlock()
+	reload()
+	return current
+}
+
+// Check checks identifiers (type:value) of an order against the current policy
+func Check(kid string, eabkid string, ids []string) *Denial {
+	return Get().Check(kid, eabkid, ids)
+}
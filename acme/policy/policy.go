@@ -0,0 +1,244 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Allow is the action of rules allowing identifiers
+	Allow = "allow"
+	// Deny is the action of rules denying identifiers
+	Deny = "deny"
+	// MatchExact matches an identifier value exactly
+	MatchExact = "exact"
+	// MatchSuffix matches a domain and its subdomains (label aware)
+	MatchSuffix = "suffix"
+	// MatchRegex matches an identifier value against an anchored regular expression
+	MatchRegex = "regex"
+	// MatchCIDR matches an ip address in a network
+	MatchCIDR = "cidr"
+)
+
+// Rule is an issuance rule for identifiers
+type Rule struct {
+	// Name names the rule in denials
+	Name string `json:"name"`
+	// Action is allow or deny
+	Action string `json:"action"`
+	// Type restricts the rule to an identifier type (dns, ip), empty for all
+	Type string `json:"type,omitempty"`
+	// Match is the kind of match: exact, suffix, regex or cidr
+	Match string `json:"match"`
+	// Value is the name, suffix, expression or network to match
+	Value   string `json:"value"`
+	regex   *regexp.Regexp
+	network *net.IPNet
+}
+
+// RuleSet is a set of rules applied to orders
+type RuleSet struct {
+	// MaxNames is the maximum number of identifiers in an order (0 for no limit)
+	MaxNames int `json:"maxNames,omitempty"`
+	// Rules are the allow and deny rules
+	Rules []*Rule `json:"rules"`
+}
+
+// Policy is the issuance policy of the server
+// the default rules apply to all accounts, rules of the account
+// and of its external account key are added to them
+type Policy struct {
+	RuleSet
+	// Accounts are the rule sets per account id
+	Accounts map[string]*RuleSet `json:"accounts,omitempty"`
+	// ExternalAccounts are the rule sets per external account key id
+	ExternalAccounts map[string]*RuleSet `json:"externalAccounts,omitempty"`
+}
+
+// Denial is the reason an order was denied
+type Denial struct {
+	// Identifier is the denied identifier (type:value)
+	Identifier string
+	// Rule is the name of the deny rule that matched
+	Rule string
+	// Detail explains the denial
+	Detail string
+}
+
+func (d *Denial) Error() string {
+	return d.Detail
+}
+
+// compile checks and prepares a rule
+func (r *Rule) compile() error {
+	if r.Action != Allow && r.Action != Deny {
+		return fmt.Errorf("rule %s: unknown action %s", r.Name, r.Action)
+	}
+	if len(r.Value) == 0 {
+		return fmt.Errorf("rule %s: empty value", r.Name)
+	}
+	switch r.Match {
+	case MatchExact:
+		r.Value = strings.ToLower(r.Value)
+	case MatchSuffix:
+		r.Value = strings.ToLower(strings.TrimPrefix(r.Value, "."))
+	case MatchRegex:
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", r.Value))
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid regex: %s", r.Name, err)
+		}
+		r.regex = re
+	case MatchCIDR:
+		_, network, err := net.ParseCIDR(r.Value)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid network: %s", r.Name, err)
+		}
+		r.network = network
+		r.Type = "ip"
+	default:
+		return fmt.Errorf("rule %s: unknown match %s", r.Name, r.Match)
+	}
+	if len(r.Name) == 0 {
+		r.Name = fmt.Sprintf("%s %s %s", r.Action, r.Match, r.Value)
+	}
+	return nil
+}
+
+// Matches checks if a rule matches an identifier
+func (r *Rule) Matches(idType string, value string) bool {
+	if len(r.Type) > 0 && r.Type != idType {
+		return false
+	}
+	value = strings.ToLower(value)
+	switch r.Match {
+	case MatchExact:
+		return value == r.Value
+	case MatchSuffix:
+		return value == r.Value || strings.HasSuffix(value, "."+r.Value)
+	case MatchRegex:
+		return r.regex.MatchString(value)
+	case MatchCIDR:
+		ip := net.ParseIP(value)
+		return ip != nil && r.network.Contains(ip)
+	}
+	return false
+}
+
+// compile checks and prepares the rules of the set
+func (s *RuleSet) compile() error {
+	if s.MaxNames < 0 {
+		return fmt.Errorf("maxNames cannot be negative")
+	}
+	for _, r := range s.Rules {
+		err := r.compile()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compile checks and prepares the rules of the policy
+func (p *Policy) compile() error {
+	err := p.RuleSet.compile()
+	if err != nil {
+		return err
+	}
+	for kid, s := range p.Accounts {
+		err = s.compile()
+		if err != nil {
+			return fmt.Errorf("account %s: %s", kid, err)
+		}
+	}
+	for kid, s := range p.ExternalAccounts {
+		err = s.compile()
+		if err != nil {
+			return fmt.Errorf("external account %s: %s", kid, err)
+		}
+	}
+	return nil
+}
+
+// sets returns the rule sets applying to an account
+// the most specific set comes first
+func (p *Policy) sets(kid string, eabkid string) []*RuleSet {
+	sets := make([]*RuleSet, 0, 3)
+	if s, ok := p.Accounts[kid]; ok && len(kid) > 0 {
+		sets = append(sets, s)
+	}
+	if s, ok := p.ExternalAccounts[eabkid]; ok && len(eabkid) > 0 {
+		sets = append(sets, s)
+	}
+	return append(sets, &p.RuleSet)
+}
+
+// Check checks identifiers (type:value) of an order against the policy
+// an identifier is denied if any deny rule matches or if no allow rule does
+func (p *Policy) Check(kid string, eabkid string, ids []string) *Denial {
+	sets := p.sets(kid, eabkid)
+	// the most specific limit applies
+	for _, s := range sets {
+		if s.MaxNames == 0 {
+			continue
+		}
+		if len(ids) > s.MaxNames {
+			return &Denial{
+				Rule:   "maxNames",
+				Detail: fmt.Sprintf("order has %d identifiers, the maximum is %d", len(ids), s.MaxNames),
+			}
+		}
+		break
+	}
+	for _, id := range ids {
+		parts := strings.SplitN(id, ":", 2)
+		if len(parts) != 2 {
+			return &Denial{Identifier: id, Detail: fmt.Sprintf("identifier %s is malformed", id)}
+		}
+		allowed := false
+		for _, s := range sets {
+			for _, r := range s.Rules {
+				if !r.Matches(parts[0], parts[1]) {
+					continue
+				}
+				if r.Action == Deny {
+					return &Denial{
+						Identifier: id,
+						Rule:       r.Name,
+						Detail:     fmt.Sprintf("identifier %s denied by rule %s", id, r.Name),
+					}
+				}
+				allowed = true
+			}
+		}
+		if !allowed {
+			return &Denial{Identifier: id, Detail: fmt.Sprintf("identifier %s is not allowed by any rule", id)}
+		}
+	}
+	return nil
+}
+
+// Default creates a policy allowing domains and networks (comma separated)
+func Default(domains string, networks string) (*Policy, error) {
+	p := &Policy{}
+	for _, d := range strings.Split(domains, ",") {
+		d = strings.TrimSpace(d)
+		if len(strings.TrimPrefix(d, ".")) == 0 {
+			continue
+		}
+		p.Rules = append(p.Rules, &Rule{Name: fmt.Sprintf("domains %s", d), Action: Allow, Type: "dns", Match: MatchSuffix, Value: d})
+	}
+	for _, n := range strings.Split(networks, ",") {
+		n = strings.TrimSpace(n)
+		if len(n) == 0 {
+			continue
+		}
+		p.Rules = append(p.Rules, &Rule{Name: fmt.Sprintf("networks %s", n), Action: Allow, Type: "ip", Match: MatchCIDR, Value: n})
+	}
+	err := p.compile()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule    Rule
+		idType  string
+		value   string
+		matches bool
+	}{
+		// exact
+		{Rule{Action: Allow, Match: MatchExact, Value: "Host.Example.com"}, "dns", "host.example.com", true},
+		{Rule{Action: Allow, Match: MatchExact, Value: "host.example.com"}, "dns", "a.host.example.com", false},
+		// suffix is label aware
+		{Rule{Action: Allow, Match: MatchSuffix, Value: "example.com"}, "dns", "example.com", true},
+		{Rule{Action: Allow, Match: MatchSuffix, Value: ".example.com"}, "dns", "a.b.Example.COM", true},
+		{Rule{Action: Allow, Match: MatchSuffix, Value: "example.com"}, "dns", "badexample.com", false},
+		{Rule{Action: Allow, Match: MatchSuffix, Value: "example.com"}, "dns", "example.com.evil", false},
+		// regex is anchored
+		{Rule{Action: Allow, Match: MatchRegex, Value: `[a-z]+\.svc\.lan`}, "dns", "web.svc.lan", true},
+		{Rule{Action: Allow, Match: MatchRegex, Value: `[a-z]+\.svc\.lan`}, "dns", "web.svc.lan.evil", false},
+		{Rule{Action: Allow, Match: MatchRegex, Value: `[a-z]+\.svc\.lan`}, "dns", "a.web.svc.lan", false},
+		{Rule{Action: Allow, Match: MatchRegex, Value: `a|b\.lan`}, "dns", "ab.lan", false},
+		// cidr only matches ip addresses
+		{Rule{Action: Allow, Match: MatchCIDR, Value: "10.0.0.0/8"}, "ip", "10.1.2.3", true},
+		{Rule{Action: Allow, Match: MatchCIDR, Value: "10.0.0.0/8"}, "ip", "11.1.2.3", false},
+		{Rule{Action: Allow, Match: MatchCIDR, Value: "fd00::/8"}, "ip", "fd00::1", true},
+		{Rule{Action: Allow, Match: MatchCIDR, Value: "10.0.0.0/8"}, "dns", "10.1.2.3", false},
+		// type restriction
+		{Rule{Action: Allow, Type: "ip", Match: MatchExact, Value: "10.0.0.1"}, "dns", "10.0.0.1", false},
+	}
+	for _, test := range tests {
+		err := test.rule.compile()
+		if err != nil {
+			t.Fatalf("cannot compile rule %s %s: %s", test.rule.Match, test.rule.Value, err)
+		}
+		if test.rule.Matches(test.idType, test.value) != test.matches {
+			t.Errorf("rule %s matches %s:%s: %t, expected %t", test.rule.Name, test.idType, test.value, !test.matches, test.matches)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules RuleSet
+		valid bool
+	}{
+		{"valid", RuleSet{MaxNames: 10, Rules: []*Rule{{Action: Deny, Match: MatchSuffix, Value: "lan"}}}, true},
+		{"unknown action", RuleSet{Rules: []*Rule{{Action: "permit", Match: MatchSuffix, Value: "lan"}}}, false},
+		{"unknown match", RuleSet{Rules: []*Rule{{Action: Allow, Match: "prefix", Value: "lan"}}}, false},
+		{"empty value", RuleSet{Rules: []*Rule{{Action: Allow, Match: MatchExact}}}, false},
+		{"invalid regex", RuleSet{Rules: []*Rule{{Action: Allow, Match: MatchRegex, Value: "(a"}}}, false},
+		{"invalid network", RuleSet{Rules: []*Rule{{Action: Allow, Match: MatchCIDR, Value: "10.0.0.0"}}}, false},
+		{"negative maxNames", RuleSet{MaxNames: -1}, false},
+	}
+	for _, test := range tests {
+		err := test.rules.compile()
+		if (err == nil) != test.valid {
+			t.Errorf("%s rule set valid: %t, expected %t (%s)", test.name, err == nil, test.valid, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{
+		RuleSet: RuleSet{
+			MaxNames: 3,
+			Rules: []*Rule{
+				{Name: "lan", Action: Allow, Match: MatchSuffix, Value: "lan"},
+				{Name: "secret", Action: Deny, Match: MatchSuffix, Value: "secret.lan"},
+				{Name: "servers", Action: Allow, Match: MatchCIDR, Value: "10.0.0.0/8"},
+			},
+		},
+		Accounts: map[string]*RuleSet{
+			"acc": {
+				MaxNames: 1,
+				Rules: []*Rule{
+					{Name: "secret web", Action: Allow, Match: MatchExact, Value: "web.secret.lan"},
+					{Name: "public", Action: Allow, Match: MatchSuffix, Value: "example.com"},
+				},
+			},
+		},
+		ExternalAccounts: map[string]*RuleSet{
+			"eab": {
+				Rules: []*Rule{
+					{Name: "no test", Action: Deny, Match: MatchRegex, Value: `test\d*\.lan`},
+				},
+			},
+		},
+	}
+	err := p.compile()
+	if err != nil {
+		t.Fatalf("cannot compile policy: %s", err)
+	}
+	tests := []struct {
+		name   string
+		kid    string
+		eabkid string
+		ids    []string
+		// rule of the denial, empty if allowed
+		rule   string
+		denied bool
+	}{
+		{"allowed", "", "", []string{"dns:a.lan", "ip:10.1.1.1"}, "", false},
+		{"not allowed", "", "", []string{"dns:a.lan", "dns:a.example.com"}, "", true},
+		{"denied", "", "", []string{"dns:web.secret.lan"}, "secret", true},
+		{"deny precedes account allow", "acc", "", []string{"dns:web.secret.lan"}, "secret", true},
+		{"account allow", "acc", "", []string{"dns:a.example.com"}, "", false},
+		{"other account", "other", "", []string{"dns:a.example.com"}, "", true},
+		{"external account deny", "", "eab", []string{"dns:test1.lan"}, "no test", true},
+		{"external account allow", "", "eab", []string{"dns:test.other.lan"}, "", false},
+		{"malformed", "", "", []string{"a.lan"}, "", true},
+		{"maxNames", "", "", []string{"dns:a.lan", "dns:b.lan", "dns:c.lan", "dns:d.lan"}, "maxNames", true},
+		{"maxNames of account", "acc", "", []string{"dns:a.lan", "dns:b.lan"}, "maxNames", true},
+		{"maxNames of default", "", "eab", []string{"dns:a.lan", "dns:b.lan", "dns:c.lan"}, "", false},
+	}
+	for _, test := range tests {
+		denial := p.Check(test.kid, test.eabkid, test.ids)
+		if (denial != nil) != test.denied {
+			t.Errorf("%s: denied %t, expected %t", test.name, denial != nil, test.denied)
+			continue
+		}
+		if denial != nil && denial.Rule != test.rule {
+			t.Errorf("%s: denied by rule %q, expected %q", test.name, denial.Rule, test.rule)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	p, err := Default(".lan, example.com,", "10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("cannot create default policy: %s", err)
+	}
+	tests := []struct {
+		id      string
+		allowed bool
+	}{
+		{"dns:a.lan", true},
+		{"dns:www.example.com", true},
+		{"dns:example.org", false},
+		{"ip:10.0.0.1", true},
+		{"ip:192.168.0.1", false},
+		{"dns:10.0.0.1", false},
+	}
+	for _, test := range tests {
+		denial := p.Check("", "", []string{test.id})
+		if (denial == nil) != test.allowed {
+			t.Errorf("%s allowed: %t, expected %t", test.id, denial == nil, test.allowed)
+		}
+	}
+	_, err = Default("lan", "10.0.0.0")
+	if err == nil {
+		t.Errorf("invalid network should fail")
+	}
+}
@@ -199,3 +199,62 @@ func UnsupportedIdentifier(c *gin.Context) {
 func UserActionRequired(c *gin.Context) {
 	problem(c, typeUserActionRequired, descUserActionRequired, http.StatusFailedDependency)
 }
+
+// newProblem creates a problem document to be attached to an object
+func newProblem(problemType string, problemDetail string, status int) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Detail: problemDetail,
+		Status: status,
+	}
+}
+
+// NewConnection creates a connection problem with a detail
+func NewConnection(detail string) *Problem {
+	return newProblem(typeConnection, detail, http.StatusNotFound)
+}
+
+// NewDNS creates a dns problem with a detail
+func NewDNS(detail string) *Problem {
+	return newProblem(typeDNS, detail, http.StatusNotFound)
+}
+
+// NewIncorrectResponse creates an incorrectResponse problem with a detail
+func NewIncorrectResponse(detail string) *Problem {
+	return newProblem(typeIncorrectResponse, detail, http.StatusExpectationFailed)
+}
+
+// NewMalformed creates a malformed problem with a detail
+func NewMalformed(detail string) *Problem {
+	return newProblem(typeMalformed, detail, http.StatusBadRequest)
+}
+
+// NewRateLimited creates a rateLimited problem with a detail
+func NewRateLimited(detail string) *Problem {
+	return newProblem(typeRateLimited, detail, http.StatusTooManyRequests)
+}
+
+// NewRejectedIdentifier creates a rejectedIdentifier problem with a detail
+func NewRejectedIdentifier(detail string) *Problem {
+	return newProblem(typeRejectedIdentifier, detail, http.StatusUnauthorized)
+}
+
+// NewServerInternal creates a serverInternal problem with a detail
+func NewServerInternal(detail string) *Problem {
+	return newProblem(typeServerInternal, detail, http.StatusInternalServerError)
+}
+
+// NewTLS creates a tls problem with a detail
+func NewTLS(detail string) *Problem {
+	return newProblem(typeTLS, detail, http.StatusFailedDependency)
+}
+
+// NewUnauthorized creates an unauthorized problem with a detail
+func NewUnauthorized(detail string) *Problem {
+	return newProblem(typeUnauthorized, detail, http.StatusUnauthorized)
+}
+
+// Send sends a problem document
+func Send(c *gin.Context, p *Problem) {
+	problem(c, p.Type, p.Detail, p.Status)
+}
@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/problem"
+	acmestore "github.com/cblomart/ACMECA/objectstore"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/publicsuffix"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// NewAccountsPerIP limits the accounts created from an ip address
+	NewAccountsPerIP = "newAccountsPerIP"
+	// NewOrdersPerAccount limits the orders created by an account
+	NewOrdersPerAccount = "newOrdersPerAccount"
+	// NewAuthorizationsPerAccount limits the pre-authorizations created by an account
+	NewAuthorizationsPerAccount = "newAuthorizationsPerAccount"
+	// CertificatesPerDomain limits the certificates issued for a registered domain
+	CertificatesPerDomain = "certificatesPerDomain"
+	// FailedValidationsPerHostname limits the failed validations of a hostname
+	FailedValidationsPerHostname = "failedValidationsPerHostname"
+)
+
+// Limit is a number of events allowed in a sliding window
+// a count of 0 disables the limit
+type Limit struct {
+	Count  int
+	Window time.Duration
+}
+
+// Limits are the limits per bucket
+var Limits = map[string]Limit{
+	NewAccountsPerIP:             {Count: 10, Window: time.Hour * 3},
+	NewOrdersPerAccount:          {Count: 300, Window: time.Hour * 3},
+	NewAuthorizationsPerAccount:  {Count: 300, Window: time.Hour * 3},
+	CertificatesPerDomain:        {Count: 50, Window: time.Hour * 24 * 7},
+	FailedValidationsPerHostname: {Count: 5, Window: time.Hour},
+}
+
+func (l Limit) String() string {
+	if l.Count == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Count, l.Window)
+}
+
+// Parse overrides limits from a list of bucket=count/window (comma separated)
+func Parse(limits string) error {
+	for _, l := range strings.Split(limits, ",") {
+		l = strings.TrimSpace(l)
+		if len(l) == 0 {
+			continue
+		}
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid rate limit %s: expecting bucket=count/window", l)
+		}
+		limit, ok := Limits[parts[0]]
+		if !ok {
+			return fmt.Errorf("unknown rate limit bucket %s", parts[0])
+		}
+		values := strings.SplitN(parts[1], "/", 2)
+		count, err := strconv.Atoi(values[0])
+		if err != nil || count < 0 {
+			return fmt.Errorf("invalid count for rate limit %s: %s", parts[0], values[0])
+		}
+		limit.Count = count
+		if len(values) == 2 {
+			window, err := time.ParseDuration(values[1])
+			if err != nil || window <= 0 {
+				return fmt.Errorf("invalid window for rate limit %s: %s", parts[0], values[1])
+			}
+			limit.Window = window
+		}
+		Limits[parts[0]] = limit
+	}
+	return nil
+}
+
+// MaxWindow is the longest window of the limits
+func MaxWindow() time.Duration {
+	max := time.Duration(0)
+	for _, l := range Limits {
+		if l.Window > max {
+			max = l.Window
+		}
+	}
+	return max
+}
+
+// Check checks if a key reached the limit of a bucket
+// returns the time after which a new event is allowed (0 if allowed)
+func Check(store acmestore.ObjectStore, bucket string, key string) (time.Duration, error) {
+	limit, ok := Limits[bucket]
+	if !ok || limit.Count == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	count, last, err := store.CountRateEvents(bucket, key, now.Add(-limit.Window), limit.Count)
+	if err != nil {
+		return 0, err
+	}
+	if count < limit.Count {
+		return 0, nil
+	}
+	// a new event is allowed once the events over the limit left the window
+	retry := last.Add(limit.Window).Sub(now)
+	if retry < time.Second {
+		retry = time.Second
+	}
+	log.Warnf("rate limit %s (%s) reached for %s", bucket, limit, key)
+	return retry, nil
+}
+
+// Record records an event in a bucket
+func Record(store acmestore.ObjectStore, bucket string, key string) error {
+	limit, ok := Limits[bucket]
+	if !ok || limit.Count == 0 {
+		return nil
+	}
+	return store.AddRateEvent(bucket, key, time.Now())
+}
+
+// RegisteredDomain returns the registered domain of an identifier value
+// ip addresses are returned as is
+func RegisteredDomain(value string) string {
+	value = strings.ToLower(strings.TrimPrefix(value, "*."))
+	if net.ParseIP(value) != nil {
+		return value
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(value)
+	if err != nil {
+		return value
+	}
+	return domain
+}
+
+// Limited sends a rateLimited problem with the time to wait
+func Limited(c *gin.Context, bucket string, key string, retry time.Duration) {
+	seconds := int(math.Ceil(retry.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	problem.Send(c, problem.NewRateLimited(fmt.Sprintf("rate limit %s (%s) reached for %s, retry after %d seconds", bucket, Limits[bucket], key, seconds)))
+}
+
+// RemoteIP returns the ip address of the peer of a request
+// forwarding headers are not trusted as they can be set by the client
+func RemoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// Enforce checks a limit for a request and records the event when allowed
+// returns false if the request was answered
+func Enforce(c *gin.Context, store acmestore.ObjectStore, bucket string, key string) bool {
+	retry, err := Check(store, bucket, key)
+	if err != nil {
+		log.Errorf("cannot check rate limit %s for %s: %s", bucket, key, err)
+		problem.ServerInternal(c)
+		return false
+	}
+	if retry > 0 {
+		Limited(c, bucket, key, retry)
+		return false
+	}
+	err = Record(store, bucket, key)
+	if err != nil {
+		log.Errorf("cannot record rate event %s for %s: %s", bucket, key, err)
+	}
+	return true
+}
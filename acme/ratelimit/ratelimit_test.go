@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cblomart/ACMECA/objectstore/memory"
+)
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"example.com", "example.com"},
+		{"a.b.example.com", "example.com"},
+		{"a.b.example.co.uk", "example.co.uk"},
+		{"*.www.example.com", "example.com"},
+		{"WWW.Example.COM", "example.com"},
+		{"host.svc.lan", "svc.lan"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"fd00::1", "fd00::1"},
+		{"co.uk", "co.uk"},
+	}
+	for _, test := range tests {
+		domain := RegisteredDomain(test.value)
+		if domain != test.expected {
+			t.Errorf("registered domain of %s: %s, expected %s", test.value, domain, test.expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	defaults := map[string]Limit{}
+	for bucket, limit := range Limits {
+		defaults[bucket] = limit
+	}
+	defer func() { Limits = defaults }()
+	tests := []struct {
+		limits string
+		bucket string
+		// expected limit when valid
+		limit Limit
+		valid bool
+	}{
+		{"newOrdersPerAccount=20/1h", NewOrdersPerAccount, Limit{20, time.Hour}, true},
+		{" newOrdersPerAccount=20/1h , ", NewOrdersPerAccount, Limit{20, time.Hour}, true},
+		{"certificatesPerDomain=5", CertificatesPerDomain, Limit{5, defaults[CertificatesPerDomain].Window}, true},
+		{"newAccountsPerIP=0", NewAccountsPerIP, Limit{0, defaults[NewAccountsPerIP].Window}, true},
+		{"newAccountsPerIP=1/1m,newAccountsPerIP=2/2m", NewAccountsPerIP, Limit{2, 2 * time.Minute}, true},
+		{"", "", Limit{}, true},
+		{"unknownBucket=1/1h", "", Limit{}, false},
+		{"newAccountsPerIP", "", Limit{}, false},
+		{"newAccountsPerIP=x/1h", "", Limit{}, false},
+		{"newAccountsPerIP=-1/1h", "", Limit{}, false},
+		{"newAccountsPerIP=1/1", "", Limit{}, false},
+		{"newAccountsPerIP=1/0s", "", Limit{}, false},
+	}
+	for _, test := range tests {
+		Limits = map[string]Limit{}
+		for bucket, limit := range defaults {
+			Limits[bucket] = limit
+		}
+		err := Parse(test.limits)
+		if (err == nil) != test.valid {
+			t.Errorf("%q valid: %t, expected %t (%s)", test.limits, err == nil, test.valid, err)
+			continue
+		}
+		if len(test.bucket) > 0 && Limits[test.bucket] != test.limit {
+			t.Errorf("%q limit: %s, expected %s", test.limits, Limits[test.bucket], test.limit)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defaults := Limits
+	defer func() { Limits = defaults }()
+	Limits = map[string]Limit{NewOrdersPerAccount: {Count: 2, Window: time.Hour}}
+	now := time.Now()
+	tests := []struct {
+		name string
+		// events ago
+		events []time.Duration
+		retry  time.Duration
+	}{
+		{"none", nil, 0},
+		{"below", []time.Duration{30 * time.Minute}, 0},
+		{"out of window", []time.Duration{90 * time.Minute, 70 * time.Minute, 30 * time.Minute}, 0},
+		{"reached", []time.Duration{50 * time.Minute, 10 * time.Minute}, 10 * time.Minute},
+		{"one over", []time.Duration{50 * time.Minute, 40 * time.Minute, 10 * time.Minute}, 20 * time.Minute},
+		{"two over", []time.Duration{10 * time.Minute, 50 * time.Minute, 30 * time.Minute, 40 * time.Minute}, 30 * time.Minute},
+	}
+	for _, test := range tests {
+		store := &memory.Store{}
+		for _, ago := range test.events {
+			err := store.AddRateEvent(NewOrdersPerAccount, "acc", now.Add(-ago))
+			if err != nil {
+				t.Fatalf("cannot add rate event: %s", err)
+			}
+		}
+		retry, err := Check(store, NewOrdersPerAccount, "acc")
+		if err != nil {
+			t.Fatalf("%s: cannot check rate limit: %s", test.name, err)
+		}
+		if retry.Round(time.Minute) != test.retry {
+			t.Errorf("%s: retry after %s, expected %s", test.name, retry, test.retry)
+		}
+	}
+}
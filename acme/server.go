@@ -1,10 +1,12 @@
 package acme
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
@@ -13,17 +15,25 @@ import (
 
 	//ginlogrus "github.com/toorop/gin-logrus"
 
+	"github.com/cblomart/ACMECA/acme/cron"
 	"github.com/cblomart/ACMECA/acme/ep"
 	"github.com/cblomart/ACMECA/acme/ep/account"
 	"github.com/cblomart/ACMECA/acme/ep/authz"
 	"github.com/cblomart/ACMECA/acme/ep/cert"
 	"github.com/cblomart/ACMECA/acme/ep/challenge"
+	"github.com/cblomart/ACMECA/acme/ep/crl"
 	"github.com/cblomart/ACMECA/acme/ep/csr"
 	"github.com/cblomart/ACMECA/acme/ep/directory"
+	"github.com/cblomart/ACMECA/acme/ep/eab"
 	"github.com/cblomart/ACMECA/acme/ep/health"
+	"github.com/cblomart/ACMECA/acme/ep/key"
 	"github.com/cblomart/ACMECA/acme/ep/nonce"
+	"github.com/cblomart/ACMECA/acme/ep/ocsp"
 	"github.com/cblomart/ACMECA/acme/ep/order"
-	"github.com/cblomart/ACMECA/acme/validator"
+	"github.com/cblomart/ACMECA/acme/ep/revoke"
+	"github.com/cblomart/ACMECA/acme/policy"
+	"github.com/cblomart/ACMECA/acme/ratelimit"
+	"github.com/cblomart/ACMECA/acme/validator/worker"
 	"github.com/cblomart/ACMECA/certstore"
 	"github.com/cblomart/ACMECA/middlewares/ca"
 	certstoremid "github.com/cblomart/ACMECA/middlewares/certstore"
@@ -33,6 +43,7 @@ import (
 	noncestoremid "github.com/cblomart/ACMECA/middlewares/noncestore"
 	objstoremid "github.com/cblomart/ACMECA/middlewares/objectstore"
 	"github.com/cblomart/ACMECA/middlewares/tokenauth"
+	"github.com/cblomart/ACMECA/middlewares/validation"
 	"github.com/cblomart/ACMECA/noncestore"
 	"github.com/cblomart/ACMECA/objectstore"
 )
@@ -55,10 +66,25 @@ func Server(v *cli.Context) error {
 		log.Warn("when using memory storage CA must be enabled")
 		modeCA = true
 	}
+	// check key algorithms
+	for _, name := range []string{"cakeyalgorithm", "httpskeyalgorithm"} {
+		err := checkKeyAlgorithm(v.String(name))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", name, err)
+		}
+	}
+	// an intermediate ca waits for its certificate to be signed by the offline root
+	if modeCA && v.Bool("intermediate") && !checkFile(v.String("cacert")) {
+		if !checkFile(v.String("cakey")) || !checkFile(v.String("cacsr")) {
+			log.Info("Generating intermediate CA key and certificate request")
+			generatecsr(v.String("cacsr"), v.String("cakey"), v.String("cakeyalgorithm"))
+		}
+		return fmt.Errorf("intermediate CA certificate %s not found: sign %s with the root CA and save the certificate followed by its issuers to %s", v.String("cacert"), v.String("cacsr"), v.String("cacert"))
+	}
 	// check that ca certificate exists
 	if modeCA && (!checkFile(v.String("cacert")) || !checkFile(v.String("cakey"))) {
 		log.Info("Generating CA certificate")
-		generatetls(v.String("cacert"), v.String("cakey"), "", "", "", true)
+		generatetls(v.String("cacert"), v.String("cakey"), "", "", "", v.String("cakeyalgorithm"), true)
 	}
 	if v.Bool("tls") {
 		log.Infof("tls enabled: checking certificates")
@@ -67,21 +93,67 @@ func Server(v *cli.Context) error {
 			if !modeCA {
 				// request certs from ca
 				log.Infof("Requesting certificate from ca %s", v.String("caurl"))
-				requesttls(v.String("httpscert"), v.String("httpskey"), v.String("hostnames"), v.String("caurl"), v.String("secret"))
+				requesttls(v.String("httpscert"), v.String("httpskey"), v.String("hostnames"), v.String("caurl"), v.String("secret"), v.String("httpskeyalgorithm"))
 			} else {
 				log.Info("Generating HTTPS certificate")
-				generatetls(v.String("httpscert"), v.String("httpskey"), v.String("hostnames"), v.String("cacert"), v.String("cakey"), false)
+				generatetls(v.String("httpscert"), v.String("httpskey"), v.String("hostnames"), v.String("cacert"), v.String("cakey"), v.String("httpskeyalgorithm"), false)
 			}
 		}
 	}
-	// allowing domains
-	validator.AllowedDomains = v.String("domains")
-	log.Infof("allowed domains: %s", validator.AllowedDomains)
+	// issuance policy
+	if len(v.String("policy")) > 0 {
+		err := policy.Load(v.String("policy"))
+		if err != nil {
+			return err
+		}
+		log.Infof("using issuance policy from %s", v.String("policy"))
+	} else {
+		p, err := policy.Default(v.String("domains"), v.String("networks"))
+		if err != nil {
+			return fmt.Errorf("invalid allowed domains or networks: %s", err)
+		}
+		policy.Set(p)
+		log.Infof("allowed domains: %s", v.String("domains"))
+		if len(v.String("networks")) > 0 {
+			log.Infof("allowed networks: %s", v.String("networks"))
+		}
+	}
+	// rate limits
+	err := ratelimit.Parse(v.String("ratelimits"))
+	if err != nil {
+		return err
+	}
+	for _, bucket := range []string{ratelimit.NewAccountsPerIP, ratelimit.NewOrdersPerAccount, ratelimit.CertificatesPerDomain, ratelimit.FailedValidationsPerHostname} {
+		log.Infof("rate limit %s: %s", bucket, ratelimit.Limits[bucket])
+	}
+	// certificate lifetime policy
+	csr.MaxValidity = v.Duration("maxvalidity")
+	csr.MinValidity = v.Duration("minvalidity")
+	if csr.MinValidity > csr.MaxValidity {
+		return fmt.Errorf("minimum validity %s exceeds maximum validity %s", csr.MinValidity, csr.MaxValidity)
+	}
+	log.Infof("certificate lifetime between %s and %s", csr.MinValidity, csr.MaxValidity)
 	r := gin.New()
 	r.Use(ginlog.Log(), gin.Recovery(), location.Default(), nocache.NoCache())
 	// acme functions
 	if modeAcme {
-		ns, err := noncestore.Factory(v.String("noncestorage"), nil)
+		if len(v.String("secret")) == 0 {
+			log.Warn("secret is not initialized, please provide a secret yourself")
+			key := make([]byte, 32)
+			_, err := rand.Read(key)
+			if err != nil {
+				return fmt.Errorf("could not generate random secret")
+			}
+			secret := base64.RawURLEncoding.EncodeToString(key)
+			log.Warnf("generated secret: %s", secret)
+			v.Set("secret", secret)
+		}
+		// hmac nonces are signed with keys derived from the secret
+		nsopts := GetOpts(v.String("noncestorageopts"))
+		if _, ok := nsopts["secret"]; !ok {
+			nsopts["secret"] = v.String("secret")
+		}
+		ns, err := noncestore.Factory(v.String("noncestorage"), nsopts)
 		if err != nil {
 			return fmt.Errorf("Cannot create requested nonce storage: %s", err)
 		}
@@ -89,24 +161,29 @@ func Server(v *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("Cannot create requested object storage: %s", err)
 		}
-		log.Infof("using '%s' nonce storage", v.String("noncestorage"))
+		if ns.Type() == "memory" {
+			log.Warnf("using '%s' nonce storage: nonces are not shared between acme servers", v.String("noncestorage"))
+		} else {
+			log.Infof("using '%s' nonce storage", v.String("noncestorage"))
+		}
 		if os.Type() == "memory" {
 			log.Warnf("using '%s' object storage", v.String("objectstorage"))
 		} else {
 			log.Infof("using '%s' object storage", v.String("objectstorage"))
 		}
-		if len(v.String("secret")) == 0 {
-			log.Warn("secret is not initialized, please provide a secret yourself")
-			key := make([]byte, 32)
-			_, err := rand.Read(key)
-			if err != nil {
-				return fmt.Errorf("could not generate random secret")
-			}
-			secret := base64.RawURLEncoding.EncodeToString(key)
-			log.Warnf("generated secret: %s", secret)
-			v.Set("secret", secret)
+		// external account binding
+		eab.Required = v.Bool("eab")
+		log.Infof("external account binding required: %t", eab.Required)
+		// expiry and garbage collection
+		if v.Bool("cron") {
+			cron.Start(os, ns, cron.Interval)
 		}
 		caInfo := ca.Info(v.String("caurl"), v.String("secret"))
+		// background validation of challenges
+		pool := worker.NewPool(os, v.Int("validationworkers"), v.Duration("validationwindow"))
+		// orders outlive the validation of their challenges, retries included
+		order.Lifetime = time.Minute*order.DefaultDurationMinutes + pool.Window() + worker.MaxBackoff
+		log.Infof("orders expire after %s", order.Lifetime)
 		base := r.Group("/")
 		base.Use(noncestoremid.Store(ns), objstoremid.Store(os), decodejws.DecodeJWS())
 		{
@@ -117,13 +194,29 @@ func Server(v *cli.Context) error {
 			base.HEAD(ep.NoncePath, nonce.Head)
 			base.POST(ep.AccountPath, account.Post)
 			base.POST(ep.AccountPath+"/:id", account.Post)
+			base.POST(ep.KeyPath, decodejws.DecodeKeyChange(), key.Post)
 			base.POST(ep.OrderPath, order.Post)
 			base.POST(ep.OrderPath+"/:id", order.Post)
+			base.POST(ep.OrdersPath+"/:id", order.ListPost)
+			base.POST(ep.AuthzPath, authz.Post)
 			base.POST(ep.AuthzPath+"/:id", authz.Post)
-			base.POST(ep.ChallengePath+"/:id", challenge.Post)
+			base.POST(ep.ChallengePath+"/:id", validation.Pool(pool), challenge.Post)
 			base.POST(ep.CsrPath+"/:id", caInfo, csr.Post)
 			base.GET(ep.CertPath+"/:id", caInfo, cert.ProxyGet)
 			base.POST(ep.CertPath+"/:id", caInfo, cert.ProxyGet)
+			base.GET(ep.CertPath+"/:id/:chain", caInfo, cert.ProxyGet)
+			base.POST(ep.CertPath+"/:id/:chain", caInfo, cert.ProxyGet)
+			base.POST(ep.RevokePath, caInfo, revoke.Post)
+			base.GET(ep.CrlPath, caInfo, crl.ProxyGet)
+		}
+		// administration functions
+		admin := r.Group(ep.AdminPath)
+		admin.Use(caInfo, tokenauth.TokenAuth(), objstoremid.Store(os))
+		{
+			admin.GET(ep.EabPath, eab.List)
+			admin.POST(ep.EabPath, eab.Post)
+			admin.DELETE(ep.EabPath+"/:id", eab.Delete)
+			admin.POST(ep.AccountPath+"/:id"+ep.RevokePath, account.Revoke)
 		}
 	}
 	// ca functions
@@ -133,8 +226,8 @@ func Server(v *cli.Context) error {
 		if !checkFile(v.String("cacert")) || !checkFile(v.String("cakey")) {
 			return fmt.Errorf("couldn't find CA cert and key")
 		}
-		// read certificate
-		crt, err := readCert(v.String("cacert"))
+		// read certificate and its issuers
+		issuers, err := readChain(v.String("cacert"))
 		if err != nil {
 			return err
 		}
@@ -143,6 +236,16 @@ func Server(v *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		issuers, err = checkChain(issuers, key, v.Bool("caroot"))
+		if err != nil {
+			return fmt.Errorf("invalid CA certificate: %s", err)
+		}
+		crt := issuers[0]
+		if crt.CheckSignatureFrom(crt) != nil {
+			log.Infof("issuing from intermediate CA %s (%d certificates in chain)", crt.Subject, len(issuers))
+		} else if v.Bool("intermediate") {
+			log.Warnf("intermediate CA certificate %s is self signed", crt.Subject)
+		}
 		// certiface store
 		cs, err := certstore.Factory(v.String("certstorage"), crt, GetOpts(v.String("certstorageopts")))
 		if err != nil {
@@ -153,14 +256,60 @@ func Server(v *cli.Context) error {
 		} else {
 			log.Infof("using '%s' cert storage", v.String("certstorage"))
 		}
+		// public url of the crl
+		crlurl := v.String("crlurl")
+		if len(crlurl) == 0 {
+			crlurl = fmt.Sprintf("%s%s", v.String("caurl"), ep.CrlPath)
+		}
+		log.Infof("publishing crl at %s", crlurl)
+		// public url of the ocsp responder
+		ocspurl := v.String("ocspurl")
+		if len(ocspurl) == 0 {
+			ocspurl = fmt.Sprintf("%s%s", v.String("caurl"), ep.OcspPath)
+		}
+		log.Infof("publishing ocsp responder at %s", ocspurl)
+		// ocsp responses are signed by the ca or by a delegated responder
+		ocspcert, ocspkey := crt, key
+		if len(v.String("ocspcert")) > 0 && len(v.String("ocspkey")) > 0 {
+			ocspcert, err = readCert(v.String("ocspcert"))
+			if err != nil {
+				return err
+			}
+			ocspkey, err = readKey(v.String("ocspkey"))
+			if err != nil {
+				return err
+			}
+			if err := ocspcert.CheckSignatureFrom(crt); err != nil {
+				log.Warnf("ocsp responder certificate not issued by the ca: %s", err)
+			}
+			log.Infof("using delegated ocsp responder %s", ocspcert.Subject.CommonName)
+		}
+		if _, ok := ocspkey.(ed25519.PrivateKey); ok {
+			log.Warn("ocsp responses cannot be signed with ed25519 keys: use a delegated responder with an rsa or ecdsa key")
+		}
+		// issuer chains served with certificates
+		chains, err := readChains(v.String("cachains"), issuers)
+		if err != nil {
+			return err
+		}
+		if len(chains) > 1 {
+			log.Infof("serving %d alternate chains", len(chains)-1)
+		}
+		crl.Refresh(cs, key, crl.RefreshInterval)
 		caGroup := r.Group("/ca")
-		caGroup.Use(ca.Info(v.String("caurl"), v.String("secret")), certstoremid.Store(cs))
+		caGroup.Use(ca.Info(v.String("caurl"), v.String("secret")), certstoremid.Store(cs), ca.Chains(chains))
 		{
 			caGroup.GET(ep.HealthPath, health.CAGet)
 			caGroup.HEAD(ep.HealthPath, health.CAGet)
+			caGroup.GET(ep.CertPath, tokenauth.TokenAuth(), cert.ListGet)
 			caGroup.GET(ep.CertPath+"/:id", cert.Get)
+			caGroup.GET(ep.CertPath+"/:id/:chain", cert.Get)
 			caGroup.DELETE(ep.CertPath+"/:id", tokenauth.TokenAuth(), cert.Delete)
-			caGroup.POST(ep.CsrPath, tokenauth.TokenAuth(), ca.Signing(key), csr.CaPost)
+			caGroup.POST(ep.CsrPath, tokenauth.TokenAuth(), ca.Signing(key), ca.Publication(crlurl, ocspurl), csr.CaPost)
+			caGroup.POST(ep.RevokePath+"/:id", tokenauth.TokenAuth(), ca.Signing(key), revoke.CaPost)
+			caGroup.GET(ep.CrlPath, ca.Signing(key), crl.Get)
+			caGroup.GET(ep.OcspPath+"/*request", ca.Responder(ocspcert, ocspkey), ocsp.Get)
+			caGroup.POST(ep.OcspPath, ca.Responder(ocspcert, ocspkey), ocsp.Post)
 		}
 	}
 	if v.Bool("tls") {
@@ -5,27 +5,31 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"net"
 
+	"github.com/cblomart/ACMECA/acme/problem"
 	"github.com/cblomart/ACMECA/acme/validator/dns"
+	"github.com/cblomart/ACMECA/acme/validator/http"
 	"github.com/cblomart/ACMECA/acme/validator/tls"
 	log "github.com/sirupsen/logrus"
 	jose "gopkg.in/square/go-jose.v2"
 )
 
 // Validate validate an acme challenge
-func Validate(domain string, validation string, token string, key string) string {
+// when the challenge is invalid a problem describing the issue is returned
+func Validate(domain string, validation string, token string, key string) (string, *problem.Problem) {
 	// create authorization key
 	// deserialize the key
 	// get the key from account
 	rawkey, err := base64.RawURLEncoding.DecodeString(key)
 	if err != nil {
 		log.Errorf("validator could not decode key: %s", err)
-		return "invalid"
+		return "invalid", problem.NewServerInternal("could not decode account key")
 	}
 	pubkey, err := x509.ParsePKIXPublicKey(rawkey)
 	if err != nil {
 		log.Errorf("validator could not parse pkix key: %s", err)
-		return "invalid"
+		return "invalid", problem.NewServerInternal("could not parse account key")
 	}
 	// create the jsonwebkey from decoded key
 	jwk := jose.JSONWebKey{Key: pubkey}
@@ -34,7 +38,7 @@ func Validate(domain string, validation string, token string, key string) string
 	rawthumb, err := jwk.Thumbprint(crypto.SHA256)
 	if err != nil {
 		log.Errorf("validator could not thumbprint the key: %s", err)
-		return "invalid"
+		return "invalid", problem.NewServerInternal("could not thumbprint account key")
 	}
 	// convert the thumbrpint to base64
 	thumb := base64.RawURLEncoding.EncodeToString(rawthumb)
@@ -42,12 +46,16 @@ func Validate(domain string, validation string, token string, key string) string
 	log.Infof("auth key: %s", authkey)
 	switch validation {
 	case "dns-01":
+		// ip addresses cannot be validated by dns (RFC 8738 §7)
+		if net.ParseIP(domain) != nil {
+			return "invalid", problem.NewMalformed(fmt.Sprintf("dns-01 cannot validate ip address %s", domain))
+		}
 		return dns.Validate(domain, authkey)
 	case "http-01":
-		return "invalid"
+		return http.Validate(domain, token, authkey)
 	case "tls-alpn-01":
 		return tls.Validate(domain, authkey)
 	default:
-		return "invalid"
+		return "invalid", problem.NewMalformed(fmt.Sprintf("unsupported challenge type: %s", validation))
 	}
 }
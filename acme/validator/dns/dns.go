@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/cblomart/ACMECA/acme/problem"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -15,7 +16,7 @@ const (
 )
 
 // Validate validates an acme dns-01 challenge
-func Validate(domain string, key string) string {
+func Validate(domain string, key string) (string, *problem.Problem) {
 	record := fmt.Sprintf("_acme-challenge.%s", domain)
 	log.Infof("dns-01: validating %s", record)
 	h := sha256.Sum256([]byte(key))
@@ -24,12 +25,12 @@ func Validate(domain string, key string) string {
 	res, err := net.LookupTXT(record)
 	if err != nil {
 		log.Errorf("error resolving %s: %s", record, err)
-		return "invalid"
+		return "invalid", problem.NewDNS(fmt.Sprintf("error resolving %s: %s", record, err))
 	}
 	for _, r := range res {
 		if r == hash {
-			return "valid"
+			return "valid", nil
 		}
 	}
-	return "invalid"
+	return "invalid", problem.NewIncorrectResponse(fmt.Sprintf("no TXT record with expected value found for %s", record))
 }
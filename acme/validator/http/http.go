@@ -1,6 +1,102 @@
 package http
 
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/problem"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// WellKnownPath is the path where challenges are exposed
+	WellKnownPath = "/.well-known/acme-challenge"
+	// timeout of the whole validation in seconds
+	timeout = 10
+	// maxRedirects is the maximum number of redirects followed
+	maxRedirects = 10
+	// maxBodySize is the maximum size of a challenge response
+	maxBodySize = 4096
+)
+
+// checkRedirect enforces RFC 8555 redirect rules (§8.3, §10.2):
+// only http and https schemes on their default ports are followed
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("too many redirects (%d)", len(via))
+	}
+	switch req.URL.Scheme {
+	case "http":
+		if len(req.URL.Port()) > 0 && req.URL.Port() != "80" {
+			return fmt.Errorf("redirect to unallowed port: %s", req.URL.Host)
+		}
+	case "https":
+		if len(req.URL.Port()) > 0 && req.URL.Port() != "443" {
+			return fmt.Errorf("redirect to unallowed port: %s", req.URL.Host)
+		}
+	default:
+		return fmt.Errorf("redirect to unallowed scheme: %s", req.URL.Scheme)
+	}
+	log.Infof("http-01: following redirect to %s", req.URL.String())
+	return nil
+}
+
+var client = http.Client{
+	Timeout:       time.Duration(timeout) * time.Second,
+	CheckRedirect: checkRedirect,
+	Transport: &http.Transport{
+		Proxy:             nil,
+		DisableKeepAlives: true,
+		// redirect to https may point to a server without a valid certificate yet
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
 // Validate validates an acme http-01 challenge
-func Validate(id string, key string) string {
-	return "invalid"
+func Validate(domain string, token string, key string) (string, *problem.Problem) {
+	host := domain
+	// ipv6 addresses are enclosed in brackets (RFC 8738 §5)
+	if ip := net.ParseIP(domain); ip != nil && ip.To4() == nil {
+		host = fmt.Sprintf("[%s]", domain)
+	}
+	challengeURL := url.URL{
+		Scheme: "http",
+		Host:   host,
+		Path:   fmt.Sprintf("%s/%s", WellKnownPath, token),
+	}
+	log.Infof("http-01: validating %s", challengeURL.String())
+	resp, err := client.Get(challengeURL.String())
+	if err != nil {
+		log.Errorf("http-01: could not fetch %s: %s", challengeURL.String(), err)
+		return "invalid", problem.NewConnection(fmt.Sprintf("could not fetch %s: %s", challengeURL.String(), err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("http-01: %s returned %s", challengeURL.String(), resp.Status)
+		return "invalid", problem.NewUnauthorized(fmt.Sprintf("invalid response from %s: %s", challengeURL.String(), resp.Status))
+	}
+	// read at most one byte more than allowed to detect oversized bodies
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		log.Errorf("http-01: could not read response from %s: %s", challengeURL.String(), err)
+		return "invalid", problem.NewConnection(fmt.Sprintf("could not read response from %s: %s", challengeURL.String(), err))
+	}
+	if len(body) > maxBodySize {
+		log.Errorf("http-01: response from %s exceeds %d bytes", challengeURL.String(), maxBodySize)
+		return "invalid", problem.NewUnauthorized(fmt.Sprintf("response from %s exceeds %d bytes", challengeURL.String(), maxBodySize))
+	}
+	// trailing whitespaces are ignored
+	received := strings.TrimRight(string(body), " \t\r\n")
+	if received != key {
+		log.Errorf("http-01: key authorization from %s didn't match", challengeURL.String())
+		return "invalid", problem.NewIncorrectResponse(fmt.Sprintf("key authorization from %s didn't match: %q", challengeURL.String(), received))
+	}
+	return "valid", nil
 }
@@ -1,24 +1,21 @@
 package validator
 
 import (
+	"net"
 	"strings"
 )
 
 const (
 	// SupportedTypes list supporte identifier types
-	SupportedTypes = "dns"
+	SupportedTypes = "dns,ip"
 )
 
-var (
-	//AllowedDomains  Allowed Domains to deliver certificates to
-	AllowedDomains = ""
-)
-
-// CheckIdentifier checks if an identifier is supported
+// CheckIdentifier checks if an identifier is supported and well formed
+// issuance policy is checked by the policy package
 // to avoid cyclic import validator should not depend on objects
 func CheckIdentifier(id string) (rejected bool, unsupported bool) {
-	// separate type and value
-	parts := strings.Split(id, ":")
+	// separate type and value (ipv6 values contain colons)
+	parts := strings.SplitN(id, ":", 2)
 	if len(parts) != 2 {
 		return true, false
 	}
@@ -33,16 +30,51 @@ func CheckIdentifier(id string) (rejected bool, unsupported bool) {
 		}
 	}
 	valueOk := false
-	for _, d := range strings.Split(strings.ToLower(AllowedDomains), ",") {
-		if strings.HasSuffix(strings.ToLower(idValue), d) {
-			valueOk = true
-			break
-		}
+	switch idType {
+	case "ip":
+		valueOk = checkIP(idValue)
+	default:
+		valueOk = checkDomain(idValue)
 	}
 	// all check passed
 	return !valueOk, !typeOk
 }
 
+// checkDomain checks that a domain is a well formed host name
+func checkDomain(domain string) bool {
+	// ip addresses must use the ip identifier type
+	if net.ParseIP(domain) != nil {
+		return false
+	}
+	// wildcard is only allowed as the complete leftmost label (RFC 8555 §7.1.3)
+	if strings.Contains(domain, "*") {
+		if !strings.HasPrefix(domain, "*.") || strings.Contains(domain[2:], "*") {
+			return false
+		}
+		domain = domain[2:]
+	}
+	if len(domain) == 0 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkIP checks that an ip address is in its canonical form (RFC 8738 §3)
+func checkIP(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.String() == value
+}
+
 // CheckIdentifiers check multiple identifiers
 func CheckIdentifiers(ids *[]string) (rejected []string, unsupported []string) {
 	rejected = make([]string, 0)
@@ -6,56 +6,81 @@ import (
 	"encoding/asn1"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
+	"github.com/cblomart/ACMECA/acme/problem"
 	log "github.com/sirupsen/logrus"
 )
 
 //ACMETLS1Protocol is the name of the alpn protocol to negociate
 const ACMETLS1Protocol = "acme-tls/1"
 
+// timeout of the whole validation
+const timeout = time.Second * 10
+
 // Validate validates an acme tls-alpn-01 challenge
-func Validate(domain string, key string) string {
+func Validate(domain string, key string) (string, *problem.Problem) {
 	// server to connect to
-	server := fmt.Sprintf("%s:443", domain)
+	server := net.JoinHostPort(domain, "443")
+	// ip addresses are indicated by their reverse dns name (RFC 8738 §6)
+	serverName := domain
+	ip := net.ParseIP(domain)
+	if ip != nil {
+		serverName = reverseName(ip)
+	}
 	// tls configuration
 	tlsConfig := &tls.Config{
-		ServerName:         domain,
+		ServerName:         serverName,
 		NextProtos:         []string{ACMETLS1Protocol},
 		InsecureSkipVerify: true,
 	}
 	// connect to the server
-	conn, err := tls.Dial("tcp", server, tlsConfig)
-	defer conn.Close()
+	// a server stalling the handshake must not hold the worker
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", server, tlsConfig)
 	if err != nil {
 		log.Errorf("could not connect to server %s: %s", domain, err)
-		return "invalid"
+		return "invalid", problem.NewConnection(fmt.Sprintf("could not connect to server %s: %s", domain, err))
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		log.Errorf("could not set deadline on connection to %s: %s", domain, err)
+		return "invalid", problem.NewConnection(fmt.Sprintf("could not set deadline on connection to %s: %s", domain, err))
 	}
 	cs := conn.ConnectionState()
 	if !cs.NegotiatedProtocolIsMutual || cs.NegotiatedProtocol != ACMETLS1Protocol {
 		log.Errorf("could not negotiate ALPN protocol %s with %s", ACMETLS1Protocol, domain)
-		return "invalid"
+		return "invalid", problem.NewTLS(fmt.Sprintf("could not negotiate ALPN protocol %s with %s", ACMETLS1Protocol, domain))
 	}
 	if len(cs.PeerCertificates) == 0 {
 		log.Errorf("ssl negociated but no peer certificate")
-		return "invalid"
+		return "invalid", problem.NewTLS("ssl negociated but no peer certificate")
 	}
 	// check cert
 	cert := cs.PeerCertificates[0]
 	// check subject alternative names
-	count := len(cert.DNSNames)
+	count := len(cert.DNSNames) + len(cert.IPAddresses)
 	if count == 0 {
 		log.Errorf("no alterntive names provided")
-		return "invalid"
+		return "invalid", problem.NewIncorrectResponse("no alternative names provided")
 	}
 	if count > 1 {
 		log.Errorf("more than one alternativeName provided")
-		return "invalid"
+		return "invalid", problem.NewIncorrectResponse("more than one alternative name provided")
 	}
-	dnsname := cert.DNSNames[0]
-	if strings.ToLower(domain) != strings.ToLower(dnsname) {
-		log.Errorf("alternativeName provided does not correspond to challenge")
-		return "invalid"
+	if ip != nil {
+		if len(cert.IPAddresses) == 0 || !ip.Equal(cert.IPAddresses[0]) {
+			log.Errorf("alternativeName provided does not correspond to challenge")
+			return "invalid", problem.NewIncorrectResponse(fmt.Sprintf("alternative name does not correspond to challenge ip %s", domain))
+		}
+	} else {
+		if len(cert.DNSNames) == 0 || strings.ToLower(domain) != strings.ToLower(cert.DNSNames[0]) {
+			log.Errorf("alternativeName provided does not correspond to challenge")
+			return "invalid", problem.NewIncorrectResponse(fmt.Sprintf("alternative name does not correspond to challenge domain %s", domain))
+		}
 	}
 	// hash to validate in the certificate
 	h := sha256.Sum256([]byte(key))
@@ -72,19 +97,33 @@ func Validate(domain string, key string) string {
 	// check that acmeIdentifier was found
 	if len(raw) == 0 {
 		log.Errorf("acmeIdentifier not present")
-		return "invalid"
+		return "invalid", problem.NewIncorrectResponse("acmeIdentifier extension not present")
 	}
 	var value []byte
 	_, err = asn1.Unmarshal(raw, &value)
 	if err != nil {
 		log.Errorf("cannot unmarshall acmeIdentifier value")
-		return "invalid"
+		return "invalid", problem.NewIncorrectResponse("cannot unmarshall acmeIdentifier value")
 	}
 	base64val := base64.RawURLEncoding.EncodeToString(value)
 	log.Infof("recieved value: %s", base64val)
 	if hash != base64val {
 		log.Errorf("acme identifier didn't have the expected value")
-		return "invalid"
+		return "invalid", problem.NewIncorrectResponse("acmeIdentifier didn't have the expected value")
+	}
+	return "valid", nil
+}
+
+// reverseName returns the reverse dns name of an ip address (in-addr.arpa or ip6.arpa)
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	name := make([]string, 0, len(ip)*2+1)
+	for i := len(ip) - 1; i >= 0; i-- {
+		name = append(name, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
 	}
-	return "valid"
+	name = append(name, "ip6.arpa")
+	return strings.Join(name, ".")
 }
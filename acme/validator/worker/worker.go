@@ -0,0 +1,246 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cblomart/ACMECA/acme/ratelimit"
+	acmestore "github.com/cblomart/ACMECA/objectstore"
+	"github.com/cblomart/ACMECA/objectstore/objects"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultWorkers is the default number of validation workers
+	DefaultWorkers = 4
+	// DefaultWindow is the default time during which a validation is retried
+	DefaultWindow = time.Minute * 5
+	// RetryAfter is the delay proposed to clients polling a processing challenge
+	RetryAfter = time.Second * 3
+	// MinBackoff is the delay before the first retry of a validation
+	MinBackoff = time.Second * 5
+	// MaxBackoff is the maximum delay between two validation attempts
+	MaxBackoff = time.Minute
+	// QueueSize is the number of validations that can wait for a worker
+	QueueSize = 256
+)
+
+// Job is a challenge validation to be run by a worker
+type Job struct {
+	// Challenge is the id of the challenge to validate
+	Challenge string
+	// Key is the key of the account (pkix base64url)
+	Key string
+	// AuthzPath is the url prefix of the authorizations
+	AuthzPath string
+	started   time.Time
+	backoff   time.Duration
+}
+
+// Pool is a pool of validation workers
+type Pool struct {
+	store    acmestore.ObjectStore
+	window   time.Duration
+	jobs     chan Job
+	inflight map[string]bool
+	mux      sync.Mutex
+}
+
+// NewPool creates a pool of validation workers and starts them
+func NewPool(store acmestore.ObjectStore, workers int, window time.Duration) *Pool {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	p := &Pool{
+		store:    store,
+		window:   window,
+		jobs:     make(chan Job, QueueSize),
+		inflight: map[string]bool{},
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	log.Infof("started %d validation workers (retrying for %s)", workers, window)
+	return p
+}
+
+// Window returns the time during which validations are retried
+func (p *Pool) Window() time.Duration {
+	return p.window
+}
+
+// Submit queues the validation of a challenge
+// a challenge already being validated is not queued again
+func (p *Pool) Submit(job Job) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.inflight[job.Challenge] {
+		return nil
+	}
+	job.started = time.Now()
+	job.backoff = MinBackoff
+	select {
+	case p.jobs <- job:
+		p.inflight[job.Challenge] = true
+		return nil
+	default:
+		return fmt.Errorf("validation queue is full")
+	}
+}
+
+// Processing indicates if a challenge is being validated
+func (p *Pool) Processing(id string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.inflight[id]
+}
+
+// work runs validations from the queue
+func (p *Pool) work() {
+	for job := range p.jobs {
+		if p.validate(job) {
+			continue
+		}
+		p.mux.Lock()
+		delete(p.inflight, job.Challenge)
+		p.mux.Unlock()
+	}
+}
+
+// retry queues a validation again after its backoff
+// the retry is dropped when the queue is full: the client can post the challenge again
+func (p *Pool) retry(job Job) {
+	time.AfterFunc(job.backoff, func() {
+		job.backoff *= 2
+		if job.backoff > MaxBackoff {
+			job.backoff = MaxBackoff
+		}
+		select {
+		case p.jobs <- job:
+		default:
+			log.Warnf("validation queue is full, dropping retry of challenge %s", job.Challenge)
+			p.mux.Lock()
+			delete(p.inflight, job.Challenge)
+			p.mux.Unlock()
+		}
+	})
+}
+
+// validate runs a validation attempt
+// returns true if the validation will be retried
+func (p *Pool) validate(job Job) bool {
+	authz, err := p.store.GetAuthorizationByChallenge(job.Challenge)
+	if err != nil || authz == nil {
+		log.Errorf("cannot retrieve authz for challenge %s: %s", job.Challenge, err)
+		return false
+	}
+	challenge := authz.Challenge(job.Challenge)
+	if challenge == nil || challenge.Status != "processing" || (authz.Status != "pending" && authz.Status != "valid") {
+		log.Warnf("challenge %s is not processing anymore", job.Challenge)
+		return false
+	}
+	// the authorization may be deactivated while validating
+	current := authz.Status
+	status, prob := authz.Validate(job.Challenge, job.Key)
+	if status != "valid" && time.Since(job.started)+job.backoff < p.window {
+		// keep the last error for clients polling the challenge
+		challenge.Error = prob
+		ok, err := p.store.CompareAndUpdateAuthorization(authz, current)
+		if err != nil {
+			log.Errorf("could not update authorization %s: %s", authz.ID, err)
+		}
+		if err == nil && !ok {
+			log.Warnf("authorization %s changed during validation of challenge %s", authz.ID, job.Challenge)
+			return false
+		}
+		log.Warnf("validation of challenge %s failed, retrying in %s", job.Challenge, job.backoff)
+		p.retry(job)
+		return true
+	}
+	authz.Complete(job.Challenge, status, prob)
+	ok, err := p.store.CompareAndUpdateAuthorization(authz, current)
+	if err != nil {
+		log.Errorf("could not update authorization %s: %s", authz.ID, err)
+		return false
+	}
+	if !ok {
+		log.Warnf("authorization %s changed during validation of challenge %s", authz.ID, job.Challenge)
+		return false
+	}
+	log.Infof("challenge %s validated: %s", job.Challenge, status)
+	if status != "valid" {
+		err = ratelimit.Record(p.store, ratelimit.FailedValidationsPerHostname, strings.ToLower(authz.Identifier.Value))
+		if err != nil {
+			log.Errorf("cannot record failed validation of %s: %s", authz.Identifier.Value, err)
+		}
+	}
+	err = UpdateOrders(p.store, authz, job.AuthzPath)
+	if err != nil {
+		log.Errorf("could not update orders of authorization %s: %s", authz.ID, err)
+	}
+	return false
+}
+
+// UpdateOrders updates the pending orders of an authorization
+// orders are ready when all their authorizations are valid
+// and invalid as soon as one of them is not
+func UpdateOrders(store acmestore.ObjectStore, authz *objects.Authorization, authzPath string) error {
+	if authz.Status == "pending" {
+		return nil
+	}
+	orders, err := store.GetOrderByAuthorization(authz.ID)
+	if err != nil {
+		return fmt.Errorf("could not retrieve orders: %s", err)
+	}
+	basePath := fmt.Sprintf("%s/", authzPath)
+	for _, o := range orders {
+		// only check pending orders
+		if o.Status != "pending" {
+			log.Warnf("authorization %s updated for order %s not pending", authz.ID, o.ID)
+			continue
+		}
+		// get the authorizations of the order
+		order, err := store.GetOrder(o.ID, authzPath)
+		if err != nil || order == nil {
+			return fmt.Errorf("could not retrieve order %s: %s", o.ID, err)
+		}
+		valid := 0
+		invalid := 0 // includes revoked, expired, deactivated
+		for _, orderAuthURL := range order.Authorizations {
+			if !strings.HasPrefix(orderAuthURL, basePath) {
+				return fmt.Errorf("authorization %s not pointing to this server", orderAuthURL)
+			}
+			id := strings.TrimPrefix(orderAuthURL, basePath)
+			orderAuthz, err := store.GetAuthorization(id)
+			if err != nil || orderAuthz == nil {
+				return fmt.Errorf("authorization with id %s not found: %s", id, err)
+			}
+			switch orderAuthz.Status {
+			case "valid":
+				valid++
+			case "pending":
+			case "invalid":
+				invalid++
+			default:
+				log.Errorf("authorization in invalid state: %s", orderAuthz.Status)
+				invalid++
+			}
+		}
+		switch {
+		case invalid > 0:
+			err = store.InvalidateOrder(order.ID)
+		case valid == len(order.Authorizations):
+			err = store.ReadyOrder(order.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("could not update order %s: %s", order.ID, err)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/cblomart/ACMECA/certstore/file"
 	"github.com/cblomart/ACMECA/certstore/memory"
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/certstore/xorm"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,6 +17,8 @@ const (
 	MemoryStore = "memory"
 	// FileStore stores certs in a folder
 	FileStore = "file"
+	// XormStore stores certs and their metadata in a database
+	XormStore = "xorm"
 )
 
 // CertStore is a noncestore
@@ -28,9 +32,25 @@ type CertStore interface {
 
 	// GetCert gets a certificate
 	GetCert(id string) (*[]byte, error)
+	// GetCertID gets the id of a certificate from its serial (empty if unknown)
+	GetCertID(serial string) (string, error)
 	// DelCert removes a certificate
 	DelCert(id string) error
-	AddCert(raw *[]byte) error
+	// AddCert adds a certificate issued to an account for an order
+	AddCert(raw *[]byte, account string, order string) error
+
+	// ListCerts lists the metadata of certificates ordered by id
+	ListCerts(filter objects.Filter) ([]objects.Certificate, error)
+
+	// RevokeCert revokes a certificate with the provided reason
+	RevokeCert(id string, reason int) error
+	// GetRevocation gets the revocation of a certificate (nil if not revoked)
+	GetRevocation(id string) (*objects.Revocation, error)
+	// GetRevocations lists the revoked certificates
+	GetRevocations() ([]objects.Revocation, error)
+
+	// NextCRLNumber increments and returns the number of the next generated CRL
+	NextCRLNumber() (int64, error)
 }
 
 // Factory creates a store in function of its type
@@ -41,6 +61,8 @@ func Factory(storeType string, cacert *x509.Certificate, args map[string]string)
 		store = &memory.Store{CA: *cacert}
 	case FileStore:
 		store = &file.Store{CA: *cacert}
+	case XormStore:
+		store = &xorm.Store{CA: *cacert}
 	default:
 		log.Errorf("unknown certificate store type requested: %s", storeType)
 		return nil, fmt.Errorf("unknown certificate store type requested: %s", storeType)
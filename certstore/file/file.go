@@ -1,17 +1,21 @@
 package file
 
 import (
-	"crypto/md5"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/certstore/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +24,10 @@ type Store struct {
 	path    string
 	CA      x509.Certificate
 	certmux sync.Mutex
+	// serials indexes certificate ids by serial
+	serials map[string]string
+	// legacy indexes certificate ids by their former id
+	legacy map[string]string
 }
 
 // Type returns the storage type
@@ -44,9 +52,93 @@ func (s *Store) Init(opts map[string]string) error {
 			return fmt.Errorf("cannot create certificate store: %s", s.path)
 		}
 	}
+	// index existing certificates by serial and former id
+	s.serials = make(map[string]string)
+	s.legacy = make(map[string]string)
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.crt", s.path))
+	if err != nil {
+		return fmt.Errorf("cannot list certificates: %s", err)
+	}
+	migrated := 0
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Warnf("cannot read certificate %s: %s", path, err)
+			continue
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			log.Warnf("cannot decode certificate %s", path)
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Warnf("cannot parse certificate %s: %s", path, err)
+			continue
+		}
+		id := utils.ID(cert.Raw)
+		old := strings.TrimSuffix(filepath.Base(path), ".crt")
+		if old != id {
+			err = s.migrate(old, id)
+			if err != nil {
+				return fmt.Errorf("cannot migrate certificate %s: %s", old, err)
+			}
+			migrated++
+		}
+		s.serials[cert.SerialNumber.String()] = id
+		s.legacy[utils.LegacyID(cert.Raw)] = id
+	}
+	if migrated > 0 {
+		log.Infof("migrated %d certificates to sha-256 ids", migrated)
+	}
+	log.Infof("indexed %d certificates", len(s.serials))
 	return nil
 }
 
+// migrate renames the files of a certificate to its new id
+// the ids in the metadata and revocation are updated
+func (s *Store) migrate(old string, id string) error {
+	for _, ext := range []string{"json", "rev"} {
+		path := fmt.Sprintf("%s/%s.%s", s.path, old, ext)
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		content := map[string]interface{}{}
+		err = json.Unmarshal(b, &content)
+		if err != nil {
+			return fmt.Errorf("cannot decode %s: %s", path, err)
+		}
+		content["id"] = id
+		b, err = json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(fmt.Sprintf("%s/%s.%s", s.path, id, ext), b, 0660)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(fmt.Sprintf("%s/%s.crt", s.path, old), fmt.Sprintf("%s/%s.crt", s.path, id))
+}
+
+// resolve gets the id of a certificate referenced by its former id
+func (s *Store) resolve(id string) string {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	if current, ok := s.legacy[id]; ok {
+		return current
+	}
+	return id
+}
+
 // GetCA gets the CA certificate
 func (s *Store) GetCA() *x509.Certificate {
 	return &s.CA
@@ -54,6 +146,7 @@ func (s *Store) GetCA() *x509.Certificate {
 
 // GetCert gets a certificate
 func (s *Store) GetCert(id string) (*[]byte, error) {
+	id = s.resolve(id)
 	// path to read
 	path := fmt.Sprintf("%s/%s.crt", s.path, id)
 	// lock cert directory
@@ -74,8 +167,16 @@ func (s *Store) GetCert(id string) (*[]byte, error) {
 	return &block.Bytes, nil
 }
 
+// GetCertID gets the id of a certificate from its serial
+func (s *Store) GetCertID(serial string) (string, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	return s.serials[serial], nil
+}
+
 // DelCert deletes a certificate
 func (s *Store) DelCert(id string) error {
+	id = s.resolve(id)
 	// path to read
 	path := fmt.Sprintf("%s/%s.crt", s.path, id)
 	// lock cert directory
@@ -85,14 +186,34 @@ func (s *Store) DelCert(id string) error {
 	if err != nil {
 		return fmt.Errorf("cannot delete certificate: %s", err)
 	}
+	err = os.Remove(fmt.Sprintf("%s/%s.json", s.path, id))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot delete certificate metadata: %s", err)
+	}
+	for serial, certid := range s.serials {
+		if certid == id {
+			delete(s.serials, serial)
+			break
+		}
+	}
+	for legacy, certid := range s.legacy {
+		if certid == id {
+			delete(s.legacy, legacy)
+			break
+		}
+	}
 	return nil
 }
 
-// AddCert adds a certicate
-func (s *Store) AddCert(raw *[]byte) error {
+// AddCert adds a certicate issued to an account for an order
+// the metadata are kept in a json file next to the certificate
+func (s *Store) AddCert(raw *[]byte, account string, order string) error {
+	cert, err := x509.ParseCertificate(*raw)
+	if err != nil {
+		return fmt.Errorf("cannot parse cert: %s", err)
+	}
 	// get the hash of the cert
-	hash := md5.Sum(*raw)
-	thumbprint := base64.RawURLEncoding.EncodeToString(hash[:])
+	thumbprint := utils.ID(*raw)
 	// lock folder
 	s.certmux.Lock()
 	defer s.certmux.Unlock()
@@ -107,5 +228,202 @@ func (s *Store) AddCert(raw *[]byte) error {
 	if err != nil {
 		return fmt.Errorf("could not create certificate file: %s", err)
 	}
+	b, err := json.Marshal(objects.NewCertificate(thumbprint, cert, account, order))
+	if err != nil {
+		return fmt.Errorf("cannot serialize certificate metadata: %s", err)
+	}
+	err = ioutil.WriteFile(fmt.Sprintf("%s/%s.json", s.path, thumbprint), b, 0660)
+	if err != nil {
+		return fmt.Errorf("could not write certificate metadata: %s", err)
+	}
+	s.serials[cert.SerialNumber.String()] = thumbprint
 	return nil
 }
+
+// info reads the metadata of a certificate
+// certificates stored without metadata are described from their content
+func (s *Store) info(id string) (*objects.Certificate, error) {
+	info := &objects.Certificate{}
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.json", s.path, id))
+	if err == nil {
+		err = json.Unmarshal(b, info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode certificate metadata %s: %s", id, err)
+		}
+	} else {
+		b, err = ioutil.ReadFile(fmt.Sprintf("%s/%s.crt", s.path, id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate %s: %s", id, err)
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("cannot decode certificate %s", id)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate %s: %s", id, err)
+		}
+		info = objects.NewCertificate(id, cert, "", "")
+		info.Raw = nil
+	}
+	b, err = ioutil.ReadFile(fmt.Sprintf("%s/%s.rev", s.path, id))
+	if err == nil {
+		revocation := objects.Revocation{}
+		err = json.Unmarshal(b, &revocation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode revocation %s: %s", id, err)
+		}
+		info.Status = objects.StatusRevoked
+		info.Reason = revocation.Reason
+		info.Revoked = &revocation.Revoked
+	}
+	return info, nil
+}
+
+// ListCerts lists the metadata of certificates ordered by id
+// the file store has no index: every certificate after the cursor is read
+// until the page is full so listing is linear with the number of certificates
+func (s *Store) ListCerts(filter objects.Filter) ([]objects.Certificate, error) {
+	// lock cert directory while listing it only
+	s.certmux.Lock()
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.crt", s.path))
+	s.certmux.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list certificates: %s", err)
+	}
+	ids := make([]string, 0, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".crt")
+		if id > filter.Cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	certs := make([]objects.Certificate, 0)
+	for _, id := range ids {
+		if filter.Limit > 0 && len(certs) == filter.Limit {
+			break
+		}
+		info, err := s.info(id)
+		if err != nil {
+			// certificate removed or being written since listed
+			log.Warnf("cannot read certificate %s: %s", id, err)
+			continue
+		}
+		if filter.Match(info, now) {
+			certs = append(certs, *info)
+		}
+	}
+	return certs, nil
+}
+
+// RevokeCert revokes a certificate
+// the revocation is kept in a json file next to the certificate
+func (s *Store) RevokeCert(id string, reason int) error {
+	id = s.resolve(id)
+	raw, err := s.GetCert(id)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(*raw)
+	if err != nil {
+		return fmt.Errorf("cannot parse cert: %s", err)
+	}
+	revocation := objects.Revocation{
+		ID:      id,
+		Serial:  cert.SerialNumber.String(),
+		Reason:  reason,
+		Revoked: time.Now(),
+	}
+	b, err := json.Marshal(revocation)
+	if err != nil {
+		return fmt.Errorf("cannot serialize revocation: %s", err)
+	}
+	// path to write
+	path := fmt.Sprintf("%s/%s.rev", s.path, id)
+	// lock cert directory
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("certificate already revoked: %s", id)
+	}
+	err = ioutil.WriteFile(path, b, 0660)
+	if err != nil {
+		return fmt.Errorf("could not write revocation file: %s", err)
+	}
+	return nil
+}
+
+// GetRevocation gets the revocation of a certificate
+func (s *Store) GetRevocation(id string) (*objects.Revocation, error) {
+	id = s.resolve(id)
+	// path to read
+	path := fmt.Sprintf("%s/%s.rev", s.path, id)
+	// lock cert directory
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation: %s", err)
+	}
+	revocation := &objects.Revocation{}
+	err = json.Unmarshal(b, revocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode revocation: %s", err)
+	}
+	return revocation, nil
+}
+
+// GetRevocations lists the revoked certificates
+func (s *Store) GetRevocations() ([]objects.Revocation, error) {
+	// lock cert directory
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.rev", s.path))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list revocations: %s", err)
+	}
+	revocations := make([]objects.Revocation, 0, len(paths))
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revocation %s: %s", path, err)
+		}
+		var revocation objects.Revocation
+		err = json.Unmarshal(b, &revocation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode revocation %s: %s", path, err)
+		}
+		revocations = append(revocations, revocation)
+	}
+	return revocations, nil
+}
+
+// NextCRLNumber increments and returns the number of the next generated CRL
+// the number of the last CRL is kept in the crlnumber file of the store
+func (s *Store) NextCRLNumber() (int64, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	path := fmt.Sprintf("%s/crlnumber", s.path)
+	number := int64(0)
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("cannot read crl number: %s", err)
+	}
+	if err == nil {
+		number, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse crl number: %s", err)
+		}
+	}
+	number++
+	err = ioutil.WriteFile(path, []byte(strconv.FormatInt(number, 10)), 0660)
+	if err != nil {
+		return 0, fmt.Errorf("cannot write crl number: %s", err)
+	}
+	return number, nil
+}
@@ -1,18 +1,26 @@
 package memory
 
 import (
-	"crypto/md5"
 	"crypto/x509"
-	"encoding/base64"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/certstore/utils"
 )
 
 // Store represent a storage of certificates
 type Store struct {
-	CA      x509.Certificate
-	certs   []x509.Certificate
-	certmux sync.Mutex
+	CA          x509.Certificate
+	certs       []x509.Certificate
+	certmux     sync.Mutex
+	revocations map[string]objects.Revocation
+	// infos are the metadata of the certificates by id
+	infos map[string]*objects.Certificate
+	// crlNumber is the number of the last generated CRL
+	crlNumber int64
 }
 
 // Type returns the storage type
@@ -22,6 +30,8 @@ func (s *Store) Type() string {
 
 // Init initalize the memory store
 func (s *Store) Init(opts map[string]string) error {
+	s.revocations = make(map[string]objects.Revocation)
+	s.infos = make(map[string]*objects.Certificate)
 	return nil
 }
 
@@ -30,48 +40,146 @@ func (s *Store) GetCA() *x509.Certificate {
 	return &s.CA
 }
 
+// find finds the index of a certificate by its id or former id (-1 if not found)
+func (s *Store) find(id string) int {
+	for i, cert := range s.certs {
+		if utils.ID(cert.Raw) == id || utils.LegacyID(cert.Raw) == id {
+			return i
+		}
+	}
+	return -1
+}
+
 // GetCert gets a certificate
 func (s *Store) GetCert(id string) (*[]byte, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	found := s.find(id)
+	if found < 0 {
+		return nil, fmt.Errorf("Certificate not found: %s", id)
+	}
+	return &s.certs[found].Raw, nil
+}
+
+// GetCertID gets the id of a certificate from its serial
+func (s *Store) GetCertID(serial string) (string, error) {
 	s.certmux.Lock()
 	defer s.certmux.Unlock()
 	for _, cert := range s.certs {
-		hash := md5.Sum(cert.Raw)
-		sign := base64.RawURLEncoding.EncodeToString(hash[:])
-		if sign == id {
-			return &cert.Raw, nil
+		if cert.SerialNumber.String() == serial {
+			return utils.ID(cert.Raw), nil
 		}
 	}
-	return nil, fmt.Errorf("Certificate not found: %s", id)
+	return "", nil
 }
 
 // DelCert deletes a certificate
 func (s *Store) DelCert(id string) error {
 	s.certmux.Lock()
 	defer s.certmux.Unlock()
-	found := -1
-	for i, cert := range s.certs {
-		hash := md5.Sum(cert.Raw)
-		sign := base64.RawURLEncoding.EncodeToString(hash[:])
-		if sign == id {
-			found = i
-		}
-	}
+	found := s.find(id)
 	if found < 0 {
 		return fmt.Errorf("Certificate not found: %s", id)
 	}
+	id = utils.ID(s.certs[found].Raw)
 	s.certs[found] = s.certs[len(s.certs)-1]
 	s.certs = s.certs[:len(s.certs)-1]
+	delete(s.infos, id)
 	return nil
 }
 
-// AddCert adds a certicate
-func (s *Store) AddCert(raw *[]byte) error {
+// AddCert adds a certicate issued to an account for an order
+func (s *Store) AddCert(raw *[]byte, account string, order string) error {
 	cert, err := x509.ParseCertificate(*raw)
 	if err != nil {
 		return fmt.Errorf("cannot parse cert: %s", err)
 	}
+	id := utils.ID(*raw)
 	s.certmux.Lock()
 	defer s.certmux.Unlock()
 	s.certs = append(s.certs, *cert)
+	s.infos[id] = objects.NewCertificate(id, cert, account, order)
 	return nil
 }
+
+// ListCerts lists the metadata of certificates ordered by id
+func (s *Store) ListCerts(filter objects.Filter) ([]objects.Certificate, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	now := time.Now()
+	certs := make([]objects.Certificate, 0)
+	for id, info := range s.infos {
+		if id <= filter.Cursor {
+			continue
+		}
+		cert := *info
+		if revocation, ok := s.revocations[id]; ok {
+			cert.Status = objects.StatusRevoked
+			cert.Reason = revocation.Reason
+			cert.Revoked = &revocation.Revoked
+		}
+		if !filter.Match(&cert, now) {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].ID < certs[j].ID })
+	if filter.Limit > 0 && len(certs) > filter.Limit {
+		certs = certs[:filter.Limit]
+	}
+	return certs, nil
+}
+
+// RevokeCert revokes a certificate
+func (s *Store) RevokeCert(id string, reason int) error {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	found := s.find(id)
+	if found < 0 {
+		return fmt.Errorf("Certificate not found: %s", id)
+	}
+	id = utils.ID(s.certs[found].Raw)
+	if _, ok := s.revocations[id]; ok {
+		return fmt.Errorf("Certificate already revoked: %s", id)
+	}
+	s.revocations[id] = objects.Revocation{
+		ID:      id,
+		Serial:  s.certs[found].SerialNumber.String(),
+		Reason:  reason,
+		Revoked: time.Now(),
+	}
+	return nil
+}
+
+// GetRevocation gets the revocation of a certificate
+func (s *Store) GetRevocation(id string) (*objects.Revocation, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	if found := s.find(id); found >= 0 {
+		id = utils.ID(s.certs[found].Raw)
+	}
+	revocation, ok := s.revocations[id]
+	if !ok {
+		return nil, nil
+	}
+	return &revocation, nil
+}
+
+// GetRevocations lists the revoked certificates
+func (s *Store) GetRevocations() ([]objects.Revocation, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	revocations := make([]objects.Revocation, 0, len(s.revocations))
+	for _, revocation := range s.revocations {
+		revocations = append(revocations, revocation)
+	}
+	return revocations, nil
+}
+
+// NextCRLNumber increments and returns the number of the next generated CRL
+func (s *Store) NextCRLNumber() (int64, error) {
+	s.certmux.Lock()
+	defer s.certmux.Unlock()
+	s.crlNumber++
+	return s.crlNumber, nil
+}
@@ -0,0 +1,74 @@
+package objects
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/cblomart/ACMECA/certstore/utils"
+)
+
+const (
+	// StatusValid is the status of a certificate that is not revoked
+	StatusValid = "valid"
+	// StatusRevoked is the status of a revoked certificate
+	StatusRevoked = "revoked"
+)
+
+// Certificate holds an issued certificate and its metadata
+type Certificate struct {
+	ID        string     `json:"id" xorm:"id pk"`
+	Serial    string     `json:"serial" xorm:"'serial' unique"`
+	Subject   string     `json:"subject" xorm:"subject"`
+	Names     []string   `json:"names" xorm:"names json"`
+	NotBefore time.Time  `json:"notBefore" xorm:"not_before index"`
+	NotAfter  time.Time  `json:"notAfter" xorm:"not_after index"`
+	Account   string     `json:"account,omitempty" xorm:"account index"`
+	Order     string     `json:"order,omitempty" xorm:"order_id index"`
+	Status    string     `json:"status" xorm:"status index"`
+	Reason    int        `json:"reason,omitempty" xorm:"reason"`
+	Revoked   *time.Time `json:"revoked,omitempty" xorm:"revoked"`
+	Raw       []byte     `json:"-" xorm:"der"`
+	LegacyID  string     `json:"-" xorm:"legacy_id index"`
+}
+
+// NewCertificate creates the metadata of an issued certificate
+// names are the lower case dns names followed by the ip addresses
+func NewCertificate(id string, cert *x509.Certificate, account string, order string) *Certificate {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	for _, name := range cert.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return &Certificate{
+		ID:        id,
+		Serial:    cert.SerialNumber.String(),
+		Subject:   cert.Subject.String(),
+		Names:     names,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Account:   account,
+		Order:     order,
+		Status:    StatusValid,
+		Raw:       cert.Raw,
+		LegacyID:  utils.LegacyID(cert.Raw),
+	}
+}
+
+// Revocation returns the revocation of a revoked certificate (nil otherwise)
+func (c *Certificate) Revocation() *Revocation {
+	if c.Status != StatusRevoked {
+		return nil
+	}
+	revocation := &Revocation{
+		ID:     c.ID,
+		Serial: c.Serial,
+		Reason: c.Reason,
+	}
+	if c.Revoked != nil {
+		revocation.Revoked = *c.Revoked
+	}
+	return revocation
+}
@@ -0,0 +1,84 @@
+package objects
+
+import (
+	"strings"
+	"time"
+)
+
+// StatusExpired is the status of a certificate that is not revoked but expired
+const StatusExpired = "expired"
+
+// Filter selects certificates when listing them
+// empty fields do not filter
+type Filter struct {
+	// Name is a dns name or ip address of the certificate
+	Name string
+	// Serial is the serial number of the certificate
+	Serial string
+	// Account is the issuing account
+	Account string
+	// ExpiresAfter selects certificates expiring after a date
+	ExpiresAfter time.Time
+	// ExpiresBefore selects certificates expiring before a date
+	ExpiresBefore time.Time
+	// IssuedAfter selects certificates valid from after a date
+	IssuedAfter time.Time
+	// IssuedBefore selects certificates valid from before a date
+	IssuedBefore time.Time
+	// Status is valid, expired or revoked
+	Status string
+	// Cursor lists the certificates with an id after it
+	Cursor string
+	// Limit is the maximum number of certificates listed (0 for no limit)
+	Limit int
+}
+
+// State returns the status of a certificate at a time (valid, expired or revoked)
+func (c *Certificate) State(now time.Time) string {
+	if c.Status == StatusRevoked {
+		return StatusRevoked
+	}
+	if !c.NotAfter.After(now) {
+		return StatusExpired
+	}
+	return StatusValid
+}
+
+// Match checks if a certificate is selected by the filter
+// the cursor and limit are handled by the listing
+func (f *Filter) Match(c *Certificate, now time.Time) bool {
+	if len(f.Name) > 0 {
+		found := false
+		for _, name := range c.Names {
+			if strings.EqualFold(name, f.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Serial) > 0 && c.Serial != f.Serial {
+		return false
+	}
+	if len(f.Account) > 0 && c.Account != f.Account {
+		return false
+	}
+	if !f.ExpiresAfter.IsZero() && !c.NotAfter.After(f.ExpiresAfter) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !c.NotAfter.Before(f.ExpiresBefore) {
+		return false
+	}
+	if !f.IssuedAfter.IsZero() && !c.NotBefore.After(f.IssuedAfter) {
+		return false
+	}
+	if !f.IssuedBefore.IsZero() && !c.NotBefore.Before(f.IssuedBefore) {
+		return false
+	}
+	if len(f.Status) > 0 && c.State(now) != f.Status {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package objects
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	valid := &Certificate{
+		Serial:    "0a",
+		Names:     []string{"www.example.com", "10.0.0.1"},
+		NotBefore: now.Add(-10 * day),
+		NotAfter:  now.Add(80 * day),
+		Account:   "acc",
+		Status:    StatusValid,
+	}
+	expired := &Certificate{
+		Serial:    "0b",
+		Names:     []string{"old.example.com"},
+		NotBefore: now.Add(-100 * day),
+		NotAfter:  now.Add(-10 * day),
+		Account:   "acc",
+		Status:    StatusValid,
+	}
+	revoked := &Certificate{
+		Serial:    "0c",
+		Names:     []string{"www.example.com"},
+		NotBefore: now.Add(-10 * day),
+		NotAfter:  now.Add(80 * day),
+		Account:   "other",
+		Status:    StatusRevoked,
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		// expected matches of the valid, expired and revoked certificates
+		matches [3]bool
+	}{
+		{"empty", Filter{}, [3]bool{true, true, true}},
+		{"name", Filter{Name: "www.example.com"}, [3]bool{true, false, true}},
+		{"name case", Filter{Name: "WWW.Example.com"}, [3]bool{true, false, true}},
+		{"name not suffix", Filter{Name: "example.com"}, [3]bool{false, false, false}},
+		{"ip", Filter{Name: "10.0.0.1"}, [3]bool{true, false, false}},
+		{"serial", Filter{Serial: "0b"}, [3]bool{false, true, false}},
+		{"account", Filter{Account: "acc"}, [3]bool{true, true, false}},
+		{"expires after", Filter{ExpiresAfter: now}, [3]bool{true, false, true}},
+		{"expires before", Filter{ExpiresBefore: now}, [3]bool{false, true, false}},
+		{"expires between", Filter{ExpiresAfter: now.Add(-20 * day), ExpiresBefore: now.Add(day)}, [3]bool{false, true, false}},
+		{"issued after", Filter{IssuedAfter: now.Add(-20 * day)}, [3]bool{true, false, true}},
+		{"issued before", Filter{IssuedBefore: now.Add(-20 * day)}, [3]bool{false, true, false}},
+		{"issued exactly", Filter{IssuedAfter: now.Add(-10 * day)}, [3]bool{false, false, false}},
+		{"valid", Filter{Status: StatusValid}, [3]bool{true, false, false}},
+		{"expired", Filter{Status: StatusExpired}, [3]bool{false, true, false}},
+		{"revoked", Filter{Status: StatusRevoked}, [3]bool{false, false, true}},
+		{"combined", Filter{Name: "www.example.com", Account: "acc", Status: StatusValid}, [3]bool{true, false, false}},
+	}
+	for _, test := range tests {
+		for i, c := range []*Certificate{valid, expired, revoked} {
+			if test.filter.Match(c, now) != test.matches[i] {
+				t.Errorf("%s filter matches certificate %s: %t, expected %t", test.name, c.Serial, !test.matches[i], test.matches[i])
+			}
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		status   string
+		notAfter time.Time
+		expected string
+	}{
+		{StatusValid, now.Add(time.Second), StatusValid},
+		{StatusValid, now, StatusExpired},
+		{StatusRevoked, now.Add(time.Second), StatusRevoked},
+		{StatusRevoked, now.Add(-time.Second), StatusRevoked},
+	}
+	for _, test := range tests {
+		c := &Certificate{Status: test.status, NotAfter: test.notAfter}
+		if c.State(now) != test.expected {
+			t.Errorf("%s certificate expiring at %s: %s, expected %s", test.status, test.notAfter, c.State(now), test.expected)
+		}
+	}
+}
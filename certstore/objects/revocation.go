@@ -0,0 +1,46 @@
+package objects
+
+import (
+	"time"
+)
+
+// Revocation reasons as defined in RFC 5280 §5.3.1
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6
+	ReasonRemoveFromCRL        = 8
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
+// Revocation represents the revocation of a certificate
+type Revocation struct {
+	ID      string    `json:"id"`
+	Serial  string    `json:"serial"`
+	Reason  int       `json:"reason"`
+	Revoked time.Time `json:"revoked"`
+}
+
+// ValidReason checks if a revocation reason is accepted
+// certificateHold (6) is refused as certificates cannot be released,
+// unused (7) and removeFromCRL (8) are not valid reasons to revoke
+func ValidReason(reason int) bool {
+	switch reason {
+	case ReasonUnspecified,
+		ReasonKeyCompromise,
+		ReasonCACompromise,
+		ReasonAffiliationChanged,
+		ReasonSuperseded,
+		ReasonCessationOfOperation,
+		ReasonPrivilegeWithdrawn,
+		ReasonAACompromise:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// ID generates the id of a certificate from the SHA-256 of its raw content
+func ID(raw []byte) string {
+	hash := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// LegacyID generates the former id of a certificate from the MD5 of its raw content
+// it is only used to find certificates referenced with their former id
+func LegacyID(raw []byte) string {
+	hash := md5.Sum(raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
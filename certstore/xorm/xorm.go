@@ -0,0 +1,319 @@
+package xorm
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cblomart/ACMECA/certstore/objects"
+	"github.com/cblomart/ACMECA/certstore/utils"
+	_ "github.com/denisenkom/go-mssqldb" // xorm support for mssql
+	_ "github.com/go-sql-driver/mysql"   // xorm support for mysql
+	_ "github.com/lib/pq"                // xorm support for postgress
+	_ "github.com/mattn/go-sqlite3"      // xorm support for sqlite
+
+	log "github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+// CertificateName indexes the names of a certificate
+type CertificateName struct {
+	ID     int64  `xorm:"id pk autoincr notnull"`
+	CertID string `xorm:"cert_id index"`
+	Name   string `xorm:"name index"`
+}
+
+// CRLNumber is the number of the last generated CRL
+type CRLNumber struct {
+	ID     int64 `xorm:"id pk notnull"`
+	Number int64 `xorm:"number"`
+}
+
+// Store stores certificates and their metadata in a database
+type Store struct {
+	CA     x509.Certificate
+	engine *xorm.Engine
+}
+
+// Type returns the storage type
+func (s *Store) Type() string {
+	return "xorm"
+}
+
+// Init initializes the xorm certificate store
+func (s *Store) Init(opts map[string]string) error {
+	drivername := "sqlite3"
+	dataSourceName := "/var/acmeca/acmeca.db"
+	if drv, ok := opts["driver"]; ok {
+		drivername = drv
+		log.Infof("using driver from opts: %s", drivername)
+	}
+	if source, ok := opts["source"]; ok {
+		dataSourceName = source
+		log.Infof("using source from opts: %s", dataSourceName)
+	}
+	engine, err := xorm.NewEngine(drivername, dataSourceName)
+	if err != nil {
+		return fmt.Errorf("could initiate xorm engine: %s", err)
+	}
+	s.engine = engine
+	err = s.engine.Sync2(new(objects.Certificate), new(CertificateName), new(CRLNumber))
+	if err != nil {
+		return fmt.Errorf("failed to sync to db: %s", err)
+	}
+	return s.migrate()
+}
+
+// migrate changes the ids of certificates stored with their former id
+func (s *Store) migrate() error {
+	certs := make([]objects.Certificate, 0)
+	err := s.engine.Where("legacy_id is null or legacy_id = ''").Cols("id", "der").Find(&certs)
+	if err != nil {
+		return fmt.Errorf("cannot list certificates to migrate: %s", err)
+	}
+	for _, cert := range certs {
+		id := utils.ID(cert.Raw)
+		session := s.engine.NewSession()
+		err = session.Begin()
+		if err != nil {
+			session.Close()
+			return fmt.Errorf("cannot start transaction: %s", err)
+		}
+		_, err = session.Table(new(objects.Certificate)).Where("id = ?", cert.ID).Update(map[string]interface{}{"id": id, "legacy_id": utils.LegacyID(cert.Raw)})
+		if err == nil {
+			_, err = session.Table(new(CertificateName)).Where("cert_id = ?", cert.ID).Update(map[string]interface{}{"cert_id": id})
+		}
+		if err != nil {
+			session.Rollback()
+			session.Close()
+			return fmt.Errorf("cannot migrate certificate %s: %s", cert.ID, err)
+		}
+		err = session.Commit()
+		session.Close()
+		if err != nil {
+			return fmt.Errorf("cannot migrate certificate %s: %s", cert.ID, err)
+		}
+	}
+	if len(certs) > 0 {
+		log.Infof("migrated %d certificates to sha-256 ids", len(certs))
+	}
+	return nil
+}
+
+// GetCA gets the CA certificate
+func (s *Store) GetCA() *x509.Certificate {
+	return &s.CA
+}
+
+// getCert gets the row of a certificate
+func (s *Store) getCert(id string) (*objects.Certificate, error) {
+	cert := &objects.Certificate{}
+	found, err := s.engine.Where("id = ? or legacy_id = ?", id, id).Get(cert)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get certificate %s: %s", id, err)
+	}
+	if !found {
+		return nil, fmt.Errorf("certificate not found: %s", id)
+	}
+	return cert, nil
+}
+
+// GetCert gets a certificate
+func (s *Store) GetCert(id string) (*[]byte, error) {
+	cert, err := s.getCert(id)
+	if err != nil {
+		return nil, err
+	}
+	return &cert.Raw, nil
+}
+
+// GetCertID gets the id of a certificate from its serial
+func (s *Store) GetCertID(serial string) (string, error) {
+	cert := &objects.Certificate{}
+	found, err := s.engine.Where("serial = ?", serial).Cols("id").Get(cert)
+	if err != nil {
+		return "", fmt.Errorf("cannot get certificate with serial %s: %s", serial, err)
+	}
+	if !found {
+		return "", nil
+	}
+	return cert.ID, nil
+}
+
+// DelCert deletes a certificate
+func (s *Store) DelCert(id string) error {
+	cert, err := s.getCert(id)
+	if err != nil {
+		return err
+	}
+	id = cert.ID
+	session := s.engine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %s", err)
+	}
+	_, err = session.Where("id = ?", id).Delete(&objects.Certificate{})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot delete certificate: %s", err)
+	}
+	_, err = session.Where("cert_id = ?", id).Delete(&CertificateName{})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot delete certificate names: %s", err)
+	}
+	return session.Commit()
+}
+
+// AddCert adds a certicate issued to an account for an order
+func (s *Store) AddCert(raw *[]byte, account string, order string) error {
+	cert, err := x509.ParseCertificate(*raw)
+	if err != nil {
+		return fmt.Errorf("cannot parse cert: %s", err)
+	}
+	row := objects.NewCertificate(utils.ID(*raw), cert, account, order)
+	session := s.engine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %s", err)
+	}
+	_, err = session.Insert(row)
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot insert certificate: %s", err)
+	}
+	for _, name := range row.Names {
+		_, err = session.Insert(&CertificateName{CertID: row.ID, Name: name})
+		if err != nil {
+			session.Rollback()
+			return fmt.Errorf("cannot insert certificate name %s: %s", name, err)
+		}
+	}
+	return session.Commit()
+}
+
+// ListCerts lists the metadata of certificates ordered by id
+func (s *Store) ListCerts(filter objects.Filter) ([]objects.Certificate, error) {
+	session := s.engine.Omit("der").Where("id > ?", filter.Cursor)
+	if len(filter.Name) > 0 {
+		session = session.And("id in (select cert_id from certificate_name where name = ?)", strings.ToLower(filter.Name))
+	}
+	if len(filter.Serial) > 0 {
+		session = session.And("serial = ?", filter.Serial)
+	}
+	if len(filter.Account) > 0 {
+		session = session.And("account = ?", filter.Account)
+	}
+	if !filter.ExpiresAfter.IsZero() {
+		session = session.And("not_after > ?", filter.ExpiresAfter)
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		session = session.And("not_after < ?", filter.ExpiresBefore)
+	}
+	if !filter.IssuedAfter.IsZero() {
+		session = session.And("not_before > ?", filter.IssuedAfter)
+	}
+	if !filter.IssuedBefore.IsZero() {
+		session = session.And("not_before < ?", filter.IssuedBefore)
+	}
+	now := time.Now()
+	switch filter.Status {
+	case "":
+	case objects.StatusRevoked:
+		session = session.And("status = ?", objects.StatusRevoked)
+	case objects.StatusExpired:
+		session = session.And("status = ? and not_after <= ?", objects.StatusValid, now)
+	case objects.StatusValid:
+		session = session.And("status = ? and not_after > ?", objects.StatusValid, now)
+	default:
+		return []objects.Certificate{}, nil
+	}
+	if filter.Limit > 0 {
+		session = session.Limit(filter.Limit)
+	}
+	certs := make([]objects.Certificate, 0)
+	err := session.Asc("id").Find(&certs)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list certificates: %s", err)
+	}
+	return certs, nil
+}
+
+// RevokeCert revokes a certificate
+func (s *Store) RevokeCert(id string, reason int) error {
+	now := time.Now()
+	update := &objects.Certificate{Status: objects.StatusRevoked, Reason: reason, Revoked: &now}
+	affected, err := s.engine.Where("(id = ? or legacy_id = ?) and status = ?", id, id, objects.StatusValid).Cols("status", "reason", "revoked").Update(update)
+	if err != nil {
+		return fmt.Errorf("cannot revoke certificate: %s", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+	_, err = s.getCert(id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("certificate already revoked: %s", id)
+}
+
+// GetRevocation gets the revocation of a certificate
+func (s *Store) GetRevocation(id string) (*objects.Revocation, error) {
+	cert := &objects.Certificate{}
+	found, err := s.engine.Where("id = ? or legacy_id = ?", id, id).Omit("der").Get(cert)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get certificate %s: %s", id, err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return cert.Revocation(), nil
+}
+
+// GetRevocations lists the revoked certificates
+func (s *Store) GetRevocations() ([]objects.Revocation, error) {
+	certs := make([]objects.Certificate, 0)
+	err := s.engine.Where("status = ?", objects.StatusRevoked).Omit("der").Find(&certs)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list revocations: %s", err)
+	}
+	revocations := make([]objects.Revocation, len(certs))
+	for i := range certs {
+		revocations[i] = *certs[i].Revocation()
+	}
+	return revocations, nil
+}
+
+// NextCRLNumber increments and returns the number of the next generated CRL
+func (s *Store) NextCRLNumber() (int64, error) {
+	session := s.engine.NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("cannot start transaction: %s", err)
+	}
+	number := CRLNumber{ID: 1}
+	ok, err := session.Get(&number)
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot get crl number: %s", err)
+	}
+	number.Number++
+	if ok {
+		_, err = session.ID(number.ID).Cols("number").Update(&number)
+	} else {
+		_, err = session.Insert(&number)
+	}
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot save crl number: %s", err)
+	}
+	err = session.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit crl number: %s", err)
+	}
+	return number.Number, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -37,6 +38,12 @@ func main() {
 				Usage:   "Key to use for HTTPS",
 				EnvVars: []string{"HTTPS_KEY"},
 			},
+			&cli.StringFlag{
+				Name:    "httpskeyalgorithm",
+				Value:   "rsa4096",
+				Usage:   "key algorithm of generated HTTPS keys (rsa2048, rsa3072, rsa4096, p256, p384, ed25519)",
+				EnvVars: []string{"HTTPS_KEY_ALGORITHM"},
+			},
 			&cli.StringFlag{
 				Name:    "hostnames",
 				Value:   "localhost",
@@ -55,6 +62,12 @@ func main() {
 				Usage:   "Nonce storage type to use",
 				EnvVars: []string{"NONCE_STORAGE"},
 			},
+			&cli.StringFlag{
+				Name:    "noncestorageopts",
+				Value:   "",
+				Usage:   "Nonce storage options (key1=value1;key2=value2;...)",
+				EnvVars: []string{"NONCE_STORAGE_OPTS"},
+			},
 			&cli.StringFlag{
 				Name:    "objectstorage",
 				Value:   "xorm",
@@ -85,6 +98,54 @@ func main() {
 				Usage:   "allowed top level domains",
 				EnvVars: []string{"DOMAINS"},
 			},
+			&cli.StringFlag{
+				Name:    "networks",
+				Value:   "",
+				Usage:   "allowed networks for ip identifiers (comma separated CIDR)",
+				EnvVars: []string{"NETWORKS"},
+			},
+			&cli.StringFlag{
+				Name:    "policy",
+				Value:   "",
+				Usage:   "issuance policy file (json, replaces domains and networks)",
+				EnvVars: []string{"POLICY"},
+			},
+			&cli.StringFlag{
+				Name:    "ratelimits",
+				Value:   "",
+				Usage:   "rate limits overrides (bucket=count/window,...)",
+				EnvVars: []string{"RATE_LIMITS"},
+			},
+			&cli.IntFlag{
+				Name:    "validationworkers",
+				Value:   4,
+				Usage:   "number of challenge validation workers",
+				EnvVars: []string{"VALIDATION_WORKERS"},
+			},
+			&cli.DurationFlag{
+				Name:    "validationwindow",
+				Value:   time.Minute * 5,
+				Usage:   "time during which failed challenge validations are retried",
+				EnvVars: []string{"VALIDATION_WINDOW"},
+			},
+			&cli.BoolFlag{
+				Name:    "eab",
+				Value:   false,
+				Usage:   "require external account binding for new accounts",
+				EnvVars: []string{"EAB"},
+			},
+			&cli.DurationFlag{
+				Name:    "maxvalidity",
+				Value:   time.Hour * 24 * 90,
+				Usage:   "maximum lifetime of certificates",
+				EnvVars: []string{"MAX_VALIDITY"},
+			},
+			&cli.DurationFlag{
+				Name:    "minvalidity",
+				Value:   time.Hour * 24,
+				Usage:   "minimum lifetime of certificates",
+				EnvVars: []string{"MIN_VALIDITY"},
+			},
 			&cli.BoolFlag{
 				Name:    "ca",
 				Value:   false,
@@ -103,6 +164,36 @@ func main() {
 				Usage:   "CA key",
 				EnvVars: []string{"CAKEY"},
 			},
+			&cli.StringFlag{
+				Name:    "cakeyalgorithm",
+				Value:   "rsa4096",
+				Usage:   "key algorithm of generated CA keys (rsa2048, rsa3072, rsa4096, p256, p384, ed25519)",
+				EnvVars: []string{"CA_KEY_ALGORITHM"},
+			},
+			&cli.BoolFlag{
+				Name:    "intermediate",
+				Value:   false,
+				Usage:   "CA is an intermediate signed by an offline root (generates cacsr when cacert is missing)",
+				EnvVars: []string{"INTERMEDIATE"},
+			},
+			&cli.StringFlag{
+				Name:    "cacsr",
+				Value:   "/etc/acmeca/certs/ca.csr",
+				Usage:   "certificate request of the intermediate CA",
+				EnvVars: []string{"CACSR"},
+			},
+			&cli.BoolFlag{
+				Name:    "caroot",
+				Value:   false,
+				Usage:   "include the root certificate of cacert in served chains",
+				EnvVars: []string{"CAROOT"},
+			},
+			&cli.StringFlag{
+				Name:    "cachains",
+				Value:   "",
+				Usage:   "alternate issuer chains (comma separated pem files starting with the CA)",
+				EnvVars: []string{"CACHAINS"},
+			},
 			&cli.BoolFlag{
 				Name:    "acme",
 				Value:   true,
@@ -122,6 +213,30 @@ func main() {
 				Usage:   "url to ca",
 				EnvVars: []string{"CASERVER"},
 			},
+			&cli.StringFlag{
+				Name:    "crlurl",
+				Value:   "",
+				Usage:   "public url of the crl (defaults to caurl/crl)",
+				EnvVars: []string{"CRLURL"},
+			},
+			&cli.StringFlag{
+				Name:    "ocspurl",
+				Value:   "",
+				Usage:   "public url of the ocsp responder (defaults to caurl/ocsp)",
+				EnvVars: []string{"OCSPURL"},
+			},
+			&cli.StringFlag{
+				Name:    "ocspcert",
+				Value:   "",
+				Usage:   "delegated OCSP responder certificate (defaults to CA)",
+				EnvVars: []string{"OCSPCERT"},
+			},
+			&cli.StringFlag{
+				Name:    "ocspkey",
+				Value:   "",
+				Usage:   "delegated OCSP responder key",
+				EnvVars: []string{"OCSPKEY"},
+			},
 			&cli.BoolFlag{
 				Name:    "cron",
 				Value:   true,
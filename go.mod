@@ -1,6 +1,6 @@
 module github.com/cblomart/ACMECA
 
-go 1.14
+go 1.15
 
 require (
 	github.com/denisenkom/go-mssqldb v0.0.0-20200206145737-bbfc9a55622e
@@ -12,6 +12,8 @@ require (
 	github.com/rs/xid v1.2.1
 	github.com/sirupsen/logrus v1.5.0
 	github.com/urfave/cli/v2 v2.2.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20200602114024-627f9648deb9
 	gopkg.in/square/go-jose.v2 v2.5.0
 	xorm.io/xorm v1.0.1
 )
@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"crypto/x509"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
@@ -45,3 +46,62 @@ func Signing(cakey interface{}) gin.HandlerFunc {
 		c.Set("cakey", cakey)
 	}
 }
+
+// Publication adds the public urls of the CA services to request
+func Publication(crlurl, ocspurl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("crlurl", crlurl)
+		c.Set("ocspurl", ocspurl)
+	}
+}
+
+// GetPublication gets the public urls of the CRL and OCSP responder
+func GetPublication(c *gin.Context) (string, string, error) {
+	crlurl, ok := c.Get("crlurl")
+	if !ok {
+		return "", "", fmt.Errorf("crl url not found")
+	}
+	ocspurl, ok := c.Get("ocspurl")
+	if !ok {
+		return "", "", fmt.Errorf("ocsp url not found")
+	}
+	return crlurl.(string), ocspurl.(string), nil
+}
+
+// Responder adds OCSP signing capabilities to request
+func Responder(cert *x509.Certificate, key interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("ocspcert", cert)
+		c.Set("ocspkey", key)
+	}
+}
+
+// GetResponder gets OCSP signing informations (cert and key)
+func GetResponder(c *gin.Context) (*x509.Certificate, interface{}, error) {
+	cert, ok := c.Get("ocspcert")
+	if !ok {
+		return nil, nil, fmt.Errorf("responder certificate not found")
+	}
+	key, ok := c.Get("ocspkey")
+	if !ok {
+		return nil, nil, fmt.Errorf("responder key not found")
+	}
+	return cert.(*x509.Certificate), key, nil
+}
+
+// Chains adds the issuer chains of the CA to request
+// the first chain is the default one, others are alternates
+func Chains(chains [][]*x509.Certificate) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("cachains", chains)
+	}
+}
+
+// GetChains gets the issuer chains of the CA
+func GetChains(c *gin.Context) ([][]*x509.Certificate, error) {
+	chains, ok := c.Get("cachains")
+	if !ok {
+		return nil, fmt.Errorf("issuer chains not found")
+	}
+	return chains.([][]*x509.Certificate), nil
+}
@@ -3,6 +3,7 @@ package decodejws
 import (
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -100,6 +101,12 @@ func DecodeJWS() gin.HandlerFunc {
 				problem.AccountDoesNotExist(c)
 				return
 			}
+			// deactivated and revoked accounts cannot be used anymore (RFC 8555 §7.3.6)
+			if account.Status != "valid" {
+				log.Errorf("request signed by %s account %s", account.Status, kid)
+				problem.Send(c, problem.NewUnauthorized(fmt.Sprintf("account is %s", account.Status)))
+				return
+			}
 			// get the key from account
 			rawkey, err := base64.RawURLEncoding.DecodeString(account.Key)
 			if err != nil {
@@ -133,6 +140,10 @@ func DecodeJWS() gin.HandlerFunc {
 			return
 		}
 		c.Set("payload", message)
+		// keep the url the request was signed for
+		if url, ok := jws.Signatures[0].Protected.ExtraHeaders["url"]; ok {
+			c.Set("url", fmt.Sprintf("%s", url))
+		}
 		// Validate nonce
 		if !ns.ValidateNonce(jws.Signatures[0].Protected.Nonce) {
 			log.Errorf("invalid nonce")
@@ -141,3 +152,113 @@ func DecodeJWS() gin.HandlerFunc {
 		}
 	}
 }
+
+// DecodeKeyChange is a middleware to decode and validate the inner JWS of a key change
+// it must be used after DecodeJWS
+func DecodeKeyChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// key change must be signed by an existing account
+		var kid string
+		if tmp, ok := c.Get("kid"); ok {
+			kid = fmt.Sprintf("%s", tmp)
+		}
+		if len(kid) == 0 {
+			log.Errorf("key change not signed by an account")
+			problem.Malformed(c)
+			return
+		}
+		var payload string
+		if tmp, ok := c.Get("payload"); ok {
+			payload = fmt.Sprintf("%s", tmp)
+		}
+		var outerURL string
+		if tmp, ok := c.Get("url"); ok {
+			outerURL = fmt.Sprintf("%s", tmp)
+		}
+		// the payload is a jws signed by the new key
+		jws, err := jose.ParseSigned(payload)
+		if err != nil {
+			log.Errorf("could not decode inner jws: %s", err)
+			problem.Malformed(c)
+			return
+		}
+		if len(jws.Signatures) != 1 {
+			log.Errorf("inner jws should have exactly one signature")
+			problem.Malformed(c)
+			return
+		}
+		protected := jws.Signatures[0].Protected
+		if protected.JSONWebKey == nil || len(protected.KeyID) > 0 {
+			log.Errorf("inner jws must be signed with a jwk")
+			problem.Malformed(c)
+			return
+		}
+		if len(protected.Nonce) > 0 {
+			log.Errorf("inner jws must not contain a nonce")
+			problem.Malformed(c)
+			return
+		}
+		innerURL, ok := protected.ExtraHeaders["url"]
+		if !ok || fmt.Sprintf("%s", innerURL) != outerURL {
+			log.Errorf("inner jws url mismatch outer jws url")
+			problem.Malformed(c)
+			return
+		}
+		message, err := jws.Verify(protected.JSONWebKey.Key)
+		if err != nil {
+			log.Errorf("could not validate inner jws: %s", err)
+			problem.Malformed(c)
+			return
+		}
+		// decode the key change
+		keyChange := struct {
+			Account string          `json:"account"`
+			OldKey  jose.JSONWebKey `json:"oldKey"`
+		}{}
+		err = json.Unmarshal(message, &keyChange)
+		if err != nil {
+			log.Errorf("could not decode key change: %s", err)
+			problem.Malformed(c)
+			return
+		}
+		url := location.Get(c).String()
+		if keyChange.Account != fmt.Sprintf("%s%s/%s", url, ep.AccountPath, kid) {
+			log.Errorf("key change for another account: %s", keyChange.Account)
+			problem.Malformed(c)
+			return
+		}
+		// old key must be the current key of the account
+		rawoldkey, err := x509.MarshalPKIXPublicKey(keyChange.OldKey.Key)
+		if err != nil {
+			log.Errorf("could not serialise old key: %s", err)
+			problem.Malformed(c)
+			return
+		}
+		os, err := objectstore.Get(c)
+		if err != nil {
+			log.Errorf("cannot retrieve object store: %s", err)
+			problem.ServerInternal(c)
+			return
+		}
+		account, err := os.GetAccount(kid)
+		if err != nil || account == nil {
+			log.Errorf("could not retrieve account with key %s: %s", kid, err)
+			problem.AccountDoesNotExist(c)
+			return
+		}
+		oldkey := base64.RawURLEncoding.EncodeToString(rawoldkey)
+		if account.Key != oldkey {
+			log.Errorf("old key does not match the key of account %s", kid)
+			problem.Malformed(c)
+			return
+		}
+		rawnewkey, err := x509.MarshalPKIXPublicKey(protected.JSONWebKey.Key)
+		if err != nil {
+			log.Errorf("could not serialise new key: %s", err)
+			problem.BadPublicKey(c)
+			return
+		}
+		c.Set("oldkey", oldkey)
+		c.Set("newkey", base64.RawURLEncoding.EncodeToString(rawnewkey))
+	}
+}
@@ -0,0 +1,24 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/cblomart/ACMECA/acme/validator/worker"
+	"github.com/gin-gonic/gin"
+)
+
+// Pool adds the validation workers to the request
+func Pool(pool *worker.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("validation", pool)
+	}
+}
+
+// Get the validation workers from a gin context
+func Get(c *gin.Context) (*worker.Pool, error) {
+	p, ok := c.Get("validation")
+	if !ok {
+		return nil, fmt.Errorf("validation workers not found")
+	}
+	return p.(*worker.Pool), nil
+}
@@ -0,0 +1,250 @@
+package hmac
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cblomart/ACMECA/noncestore/utils"
+	_ "github.com/denisenkom/go-mssqldb" // xorm support for mssql
+	_ "github.com/go-sql-driver/mysql"   // xorm support for mysql
+	_ "github.com/lib/pq"                // xorm support for postgress
+	_ "github.com/mattn/go-sqlite3"      // xorm support for sqlite
+
+	log "github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+const (
+	// Skew is the tolerated clock difference between acme servers
+	Skew = time.Minute
+	// BloomBits is the size of the filter of consumed nonces per key period
+	BloomBits = 1 << 22
+	// BloomHashes is the number of bits set per consumed nonce
+	BloomHashes = 4
+	// SharedReplay is the replay option value keeping the consumed nonces
+	// in a database shared between acme servers
+	SharedReplay = "shared"
+
+	timeLen    = 8
+	instLen    = 4
+	counterLen = 8
+	macLen     = 16
+	payloadLen = timeLen + instLen + counterLen
+	nonceLen   = payloadLen + macLen
+)
+
+// ConsumedNonce is a consumed nonce shared between acme servers
+type ConsumedNonce struct {
+	Mac    string `xorm:"mac pk notnull"`
+	Period int64  `xorm:"period index"`
+}
+
+// bloom is a bloom filter of consumed nonces
+type bloom struct {
+	bits  []uint64
+	count int
+}
+
+// indexes returns the bits of a nonce from its mac
+func indexes(mac []byte) []uint32 {
+	idx := make([]uint32, BloomHashes)
+	for i := range idx {
+		idx[i] = binary.BigEndian.Uint32(mac[i*4:]) % BloomBits
+	}
+	return idx
+}
+
+// add adds a nonce to the filter and indicates if it may already have been present
+func (b *bloom) add(mac []byte) bool {
+	present := true
+	for _, i := range indexes(mac) {
+		if b.bits[i/64]&(1<<(i%64)) == 0 {
+			present = false
+			b.bits[i/64] |= 1 << (i % 64)
+		}
+	}
+	if !present {
+		b.count++
+	}
+	return present
+}
+
+// Store mints nonces signed with a key derived from the shared secret
+// and their period so that any acme server sharing the secret validates them.
+// Keys rotate every period and nonces are not stored: consumed nonces are
+// kept in a bloom filter per period (BloomBits bits) dropped once the period
+// has no valid nonce, so memory does not grow with the number of nonces.
+// Filters are not shared: a nonce consumed on a server is still accepted
+// once by each other server. With the shared replay option consumed nonces
+// are kept in a database instead so that any server detects the replay.
+type Store struct {
+	secret   []byte
+	instance []byte
+	counter  uint64
+	engine   *xorm.Engine
+	consumed map[int64]*bloom
+	mux      sync.Mutex
+}
+
+// Type returns the storage type
+func (s *Store) Type() string {
+	return "hmac"
+}
+
+// Init initializes the hmac nonce store
+func (s *Store) Init(opts map[string]string) error {
+	secret, ok := opts["secret"]
+	if !ok || len(secret) == 0 {
+		return fmt.Errorf("hmac nonce store requires a secret")
+	}
+	s.secret = []byte(secret)
+	s.instance = make([]byte, instLen)
+	_, err := rand.Read(s.instance)
+	if err != nil {
+		return fmt.Errorf("cannot generate instance id: %s", err)
+	}
+	if opts["replay"] != SharedReplay {
+		log.Warnf("hmac nonces are consumed per acme server: a nonce can be replayed once on each other server")
+		s.consumed = map[int64]*bloom{}
+		return nil
+	}
+	drivername := "sqlite3"
+	dataSourceName := "/var/acmeca/acmeca.db"
+	if drv, ok := opts["driver"]; ok {
+		drivername = drv
+		log.Infof("using driver from opts: %s", drivername)
+	}
+	if source, ok := opts["source"]; ok {
+		dataSourceName = source
+		log.Infof("using source from opts: %s", dataSourceName)
+	}
+	engine, err := xorm.NewEngine(drivername, dataSourceName)
+	if err != nil {
+		return fmt.Errorf("could initiate xorm engine: %s", err)
+	}
+	s.engine = engine
+	err = s.engine.Sync2(new(ConsumedNonce))
+	if err != nil {
+		return fmt.Errorf("failed to sync to db: %s", err)
+	}
+	return nil
+}
+
+// period returns the key period of a time
+func period(t time.Time) int64 {
+	return t.Unix() / int64(utils.Validity/time.Second)
+}
+
+// key derives the key of a period from the secret
+func (s *Store) key(p int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(p))
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte("acmeca nonce key"))
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// sign signs the payload of a nonce with a key
+func sign(payload []byte, key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return h.Sum(nil)[:macLen]
+}
+
+// ValidateNonce indicates if a nonce is valid then marks it as consumed
+func (s *Store) ValidateNonce(nonce string) bool {
+	return s.validate(nonce, time.Now())
+}
+
+// validate validates a nonce at a time
+func (s *Store) validate(nonce string, now time.Time) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != nonceLen {
+		return false
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(raw[:timeLen])), 0)
+	if now.Sub(issued) >= utils.Validity || issued.Sub(now) > Skew {
+		return false
+	}
+	p := period(issued)
+	mac := raw[payloadLen:]
+	if !hmac.Equal(mac, sign(raw[:payloadLen], s.key(p))) {
+		return false
+	}
+	if s.engine != nil {
+		// the primary key only lets one request consume the nonce
+		_, err := s.engine.Insert(&ConsumedNonce{Mac: base64.RawURLEncoding.EncodeToString(mac), Period: p})
+		if err != nil {
+			log.Debugf("cannot consume nonce: %s", err)
+			return false
+		}
+		return true
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	b, ok := s.consumed[p]
+	if !ok {
+		s.clean(now)
+		b = &bloom{bits: make([]uint64, BloomBits/64)}
+		s.consumed[p] = b
+	}
+	return !b.add(mac)
+}
+
+// GetNonce generates a new nonce
+func (s *Store) GetNonce() (string, error) {
+	return s.mint(time.Now())
+}
+
+// mint generates a nonce issued at a time
+func (s *Store) mint(now time.Time) (string, error) {
+	if s.secret == nil {
+		return "", fmt.Errorf("hmac nonce store not initialized")
+	}
+	raw := make([]byte, payloadLen, nonceLen)
+	binary.BigEndian.PutUint64(raw, uint64(now.Unix()))
+	copy(raw[timeLen:], s.instance)
+	binary.BigEndian.PutUint64(raw[timeLen+instLen:], atomic.AddUint64(&s.counter, 1))
+	raw = append(raw, sign(raw, s.key(period(now)))...)
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// clean drops the filters of periods without valid nonces
+func (s *Store) clean(now time.Time) int {
+	count := 0
+	for p, b := range s.consumed {
+		if p < period(now.Add(-utils.Validity)) {
+			count += b.count
+			delete(s.consumed, p)
+		}
+	}
+	return count
+}
+
+// Clean removes the consumed nonces of expired periods
+func (s *Store) Clean() (int, error) {
+	return s.purge(time.Now())
+}
+
+// purge removes the consumed nonces of periods without valid nonces at a time
+func (s *Store) purge(now time.Time) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.engine == nil {
+		return s.clean(now), nil
+	}
+	expired := period(now.Add(-utils.Validity))
+	affected, err := s.engine.Where("period < ?", expired).Delete(&ConsumedNonce{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot clean consumed nonces: %s", err)
+	}
+	return int(affected), nil
+}
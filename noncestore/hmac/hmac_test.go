@@ -0,0 +1,203 @@
+package hmac
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cblomart/ACMECA/noncestore/utils"
+)
+
+// newStores creates two acme servers sharing their secret and database
+func newStores(t *testing.T, replay string) (*Store, *Store, func()) {
+	dir, err := ioutil.TempDir("", "hmac")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	opts := map[string]string{"secret": "s3cr3t", "replay": replay, "source": filepath.Join(dir, "nonces.db")}
+	a := &Store{}
+	err = a.Init(opts)
+	if err != nil {
+		t.Fatalf("cannot init store: %s", err)
+	}
+	b := &Store{}
+	err = b.Init(opts)
+	if err != nil {
+		t.Fatalf("cannot init store: %s", err)
+	}
+	return a, b, func() { os.RemoveAll(dir) }
+}
+
+// tamper changes the issue time of a nonce
+func tamper(t *testing.T, nonce string, d time.Duration) string {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		t.Fatalf("cannot decode nonce: %s", err)
+	}
+	issued := int64(binary.BigEndian.Uint64(raw[:timeLen]))
+	binary.BigEndian.PutUint64(raw[:timeLen], uint64(issued+int64(d/time.Second)))
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestInitRequiresSecret(t *testing.T) {
+	for _, replay := range []string{"", SharedReplay} {
+		s := &Store{}
+		err := s.Init(map[string]string{"replay": replay})
+		if err == nil {
+			t.Errorf("replay %q: store without secret should fail", replay)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		replay string
+		// replay on another server is detected
+		shared bool
+	}{
+		{"", false},
+		{SharedReplay, true},
+	}
+	for _, test := range tests {
+		a, b, cleanup := newStores(t, test.replay)
+		n, err := a.GetNonce()
+		if err != nil {
+			t.Fatalf("replay %q: cannot get nonce: %s", test.replay, err)
+		}
+		if len(n) != 48 {
+			t.Errorf("replay %q: nonce length %d, expected 48", test.replay, len(n))
+		}
+		m, _ := a.GetNonce()
+		if n == m {
+			t.Errorf("replay %q: nonces are not unique", test.replay)
+		}
+		if !b.ValidateNonce(n) {
+			t.Errorf("replay %q: nonce not valid on another server", test.replay)
+		}
+		if b.ValidateNonce(n) {
+			t.Errorf("replay %q: nonce replayed on the same server", test.replay)
+		}
+		if a.ValidateNonce(n) == test.shared {
+			t.Errorf("replay %q: replay on another server detected: %t, expected %t", test.replay, !test.shared, test.shared)
+		}
+		if !a.ValidateNonce(m) {
+			t.Errorf("replay %q: nonce not valid on issuing server", test.replay)
+		}
+		cleanup()
+	}
+}
+
+func TestValidity(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name  string
+		at    time.Duration
+		valid bool
+	}{
+		{"issued", 0, true},
+		{"before expiry", utils.Validity - time.Second, true},
+		{"expired", utils.Validity, false},
+		{"within skew", -Skew, true},
+		{"beyond skew", -Skew - time.Second, false},
+	}
+	for _, replay := range []string{"", SharedReplay} {
+		a, b, cleanup := newStores(t, replay)
+		for _, test := range tests {
+			n, err := a.mint(now)
+			if err != nil {
+				t.Fatalf("replay %q: cannot get nonce: %s", replay, err)
+			}
+			if b.validate(n, now.Add(test.at)) != test.valid {
+				t.Errorf("replay %q: %s nonce valid: %t, expected %t", replay, test.name, !test.valid, test.valid)
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestTampered(t *testing.T) {
+	a, b, cleanup := newStores(t, "")
+	defer cleanup()
+	now := time.Now()
+	n, err := a.GetNonce()
+	if err != nil {
+		t.Fatalf("cannot get nonce: %s", err)
+	}
+	mac := []byte(n)
+	mac[len(mac)-2] ^= 1
+	tests := []struct {
+		name  string
+		nonce string
+	}{
+		{"earlier timestamp", tamper(t, n, -time.Second)},
+		{"later timestamp", tamper(t, n, time.Second)},
+		{"extended timestamp", tamper(t, n, utils.Validity)},
+		{"tampered mac", string(mac)},
+		{"truncated", n[:40]},
+		{"not base64", "!" + n[1:]},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		if b.validate(test.nonce, now) {
+			t.Errorf("%s nonce is valid", test.name)
+		}
+	}
+	if !b.validate(n, now) {
+		t.Errorf("nonce is not valid after tampered ones")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	a, b, cleanup := newStores(t, "")
+	defer cleanup()
+	now := time.Now()
+	if string(a.key(period(now))) != string(b.key(period(now))) {
+		t.Errorf("key is not shared between servers")
+	}
+	if string(a.key(period(now))) == string(a.key(period(now)+1)) {
+		t.Errorf("key did not rotate")
+	}
+	other := &Store{}
+	err := other.Init(map[string]string{"secret": "other"})
+	if err != nil {
+		t.Fatalf("cannot init store: %s", err)
+	}
+	if string(other.key(period(now))) == string(a.key(period(now))) {
+		t.Errorf("key does not depend on the secret")
+	}
+	n, err := other.mint(now)
+	if err != nil {
+		t.Fatalf("cannot get nonce: %s", err)
+	}
+	if b.validate(n, now) {
+		t.Errorf("nonce of another secret is valid")
+	}
+}
+
+func TestClean(t *testing.T) {
+	now := time.Now()
+	for _, replay := range []string{"", SharedReplay} {
+		a, b, cleanup := newStores(t, replay)
+		n, err := a.mint(now)
+		if err != nil {
+			t.Fatalf("replay %q: cannot get nonce: %s", replay, err)
+		}
+		if !b.validate(n, now) {
+			t.Errorf("replay %q: nonce not valid", replay)
+		}
+		count, err := b.purge(now)
+		if err != nil || count != 0 {
+			t.Errorf("replay %q: consumed nonce of a valid period removed: %d (%s)", replay, count, err)
+		}
+		// once no nonce of a period is valid its consumed nonces are removed
+		count, err = b.purge(now.Add(2 * utils.Validity))
+		if err != nil || count != 1 {
+			t.Errorf("replay %q: removed %d consumed nonces, expected 1 (%s)", replay, count, err)
+		}
+		cleanup()
+	}
+}
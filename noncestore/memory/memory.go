@@ -2,13 +2,15 @@ package memory
 
 import (
 	"sync"
+	"time"
 
 	"github.com/cblomart/ACMECA/noncestore/utils"
 )
 
 // Store stores ACME objects in memory
 type Store struct {
-	nonces   []string
+	// nonces with their issue time
+	nonces   map[string]time.Time
 	noncemux sync.Mutex
 }
 
@@ -21,21 +23,14 @@ func (s *Store) Type() string {
 func (s *Store) ValidateNonce(nonce string) bool {
 	s.noncemux.Lock()
 	defer s.noncemux.Unlock()
-	i := -1
-	for j, n := range s.nonces {
-		if n == nonce {
-			i = j
-			break
-		}
-	}
-	if i >= 0 {
-		// if element found remove
-		s.nonces[i] = s.nonces[len(s.nonces)-1]
-		s.nonces = s.nonces[:len(s.nonces)-1]
-		// validate nonce
-		return true
+	issued, ok := s.nonces[nonce]
+	if !ok {
+		return false
 	}
-	return false
+	// if element found remove
+	delete(s.nonces, nonce)
+	// validate nonce if not expired
+	return time.Since(issued) < utils.Validity
 }
 
 // GetNonce generates a new nonce
@@ -47,10 +42,22 @@ func (s *Store) GetNonce() (string, error) {
 	s.noncemux.Lock()
 	defer s.noncemux.Unlock()
 	if s.nonces == nil {
-		s.nonces = make([]string, 1)
-		s.nonces[0] = nonce
-	} else {
-		s.nonces = append(s.nonces, nonce)
+		s.nonces = map[string]time.Time{}
 	}
+	s.nonces[nonce] = time.Now()
 	return nonce, nil
 }
+
+// Clean removes the expired nonces
+func (s *Store) Clean() (int, error) {
+	s.noncemux.Lock()
+	defer s.noncemux.Unlock()
+	count := 0
+	for nonce, issued := range s.nonces {
+		if time.Since(issued) >= utils.Validity {
+			delete(s.nonces, nonce)
+			count++
+		}
+	}
+	return count, nil
+}
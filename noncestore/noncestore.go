@@ -3,7 +3,9 @@ package noncestore
 import (
 	"fmt"
 
+	"github.com/cblomart/ACMECA/noncestore/hmac"
 	"github.com/cblomart/ACMECA/noncestore/memory"
+	"github.com/cblomart/ACMECA/noncestore/xorm"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -11,6 +13,10 @@ import (
 const (
 	// MemoryStore stores nonces in memory
 	MemoryStore = "memory"
+	// XormStore stores nonces in a database shared by acme servers
+	XormStore = "xorm"
+	// HmacStore mints nonces signed with a key derived from the shared secret
+	HmacStore = "hmac"
 )
 
 // NonceStore is a noncestore
@@ -23,6 +29,8 @@ type NonceStore interface {
 	ValidateNonce(nonce string) bool
 	// RegisterNonce register a provided nonce
 	GetNonce() (string, error)
+	// Clean removes the expired nonces
+	Clean() (int, error)
 }
 
 // Factory creates a store in function of its type
@@ -30,6 +38,20 @@ func Factory(storeType string, args map[string]string) (NonceStore, error) {
 	switch storeType {
 	case MemoryStore:
 		return &memory.Store{}, nil
+	case XormStore:
+		store := &xorm.Store{}
+		err := store.Init(args)
+		if err != nil {
+			return nil, fmt.Errorf("could not init xorm storage: %s", err)
+		}
+		return store, nil
+	case HmacStore:
+		store := &hmac.Store{}
+		err := store.Init(args)
+		if err != nil {
+			return nil, fmt.Errorf("could not init hmac storage: %s", err)
+		}
+		return store, nil
 	default:
 		log.Errorf("unknown nonce store type requested: %s", storeType)
 		return nil, fmt.Errorf("unknown nonce store type requested: %s", storeType)
@@ -3,6 +3,12 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"time"
+)
+
+const (
+	// Validity is the time during which an issued nonce can be used
+	Validity = time.Hour
 )
 
 // GenerateNonce generates a nonce
@@ -0,0 +1,88 @@
+package xorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cblomart/ACMECA/noncestore/utils"
+	_ "github.com/denisenkom/go-mssqldb" // xorm support for mssql
+	_ "github.com/go-sql-driver/mysql"   // xorm support for mysql
+	_ "github.com/lib/pq"                // xorm support for postgress
+	_ "github.com/mattn/go-sqlite3"      // xorm support for sqlite
+
+	log "github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+// Nonce is an issued nonce
+type Nonce struct {
+	Value  string    `xorm:"value pk notnull"`
+	Issued time.Time `xorm:"issued index"`
+}
+
+// Store stores nonces in a database shared between acme servers
+type Store struct {
+	engine *xorm.Engine
+}
+
+// Type returns the storage type
+func (s *Store) Type() string {
+	return "xorm"
+}
+
+// Init initializes the xorm nonce store
+func (s *Store) Init(opts map[string]string) error {
+	drivername := "sqlite3"
+	dataSourceName := "/var/acmeca/acmeca.db"
+	if drv, ok := opts["driver"]; ok {
+		drivername = drv
+		log.Infof("using driver from opts: %s", drivername)
+	}
+	if source, ok := opts["source"]; ok {
+		dataSourceName = source
+		log.Infof("using source from opts: %s", dataSourceName)
+	}
+	engine, err := xorm.NewEngine(drivername, dataSourceName)
+	if err != nil {
+		return fmt.Errorf("could initiate xorm engine: %s", err)
+	}
+	s.engine = engine
+	err = s.engine.Sync2(new(Nonce))
+	if err != nil {
+		return fmt.Errorf("failed to sync to db: %s", err)
+	}
+	return nil
+}
+
+// ValidateNonce indicates if a nonce is valid then removes it from the store
+// the nonce is consumed by a single delete so that only one request can use it
+func (s *Store) ValidateNonce(nonce string) bool {
+	affected, err := s.engine.Where("value = ? and issued > ?", nonce, time.Now().Add(-utils.Validity)).Delete(&Nonce{})
+	if err != nil {
+		log.Errorf("cannot consume nonce: %s", err)
+		return false
+	}
+	return affected == 1
+}
+
+// GetNonce generates a new nonce
+func (s *Store) GetNonce() (string, error) {
+	nonce, err := utils.GenerateNonce()
+	if err != nil {
+		return "", err
+	}
+	_, err = s.engine.Insert(&Nonce{Value: nonce, Issued: time.Now()})
+	if err != nil {
+		return "", fmt.Errorf("cannot insert nonce: %s", err)
+	}
+	return nonce, nil
+}
+
+// Clean removes the expired nonces
+func (s *Store) Clean() (int, error) {
+	affected, err := s.engine.Where("issued <= ?", time.Now().Add(-utils.Validity)).Delete(&Nonce{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot clean nonces: %s", err)
+	}
+	return int(affected), nil
+}
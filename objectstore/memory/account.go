@@ -79,33 +79,52 @@ func (s *Store) UpdateAccount(account objects.Account) (*objects.Account, error)
 			break
 		}
 	}
-	if i > 0 {
-		s.accounts[i].Update(account)
-		log.Infof("Account (%d total) - updated: %s", len(s.accounts), s.accounts[i].KeyID)
+	if i < 0 {
+		return nil, fmt.Errorf("account %s not found", account.KeyID)
 	}
+	s.accounts[i].Update(account)
+	log.Infof("Account (%d total) - updated: %s", len(s.accounts), s.accounts[i].KeyID)
 	return &s.accounts[i], nil
 }
 
-// RevokeAccount revokes an account (on admin/server request)
-func (s *Store) RevokeAccount(kid string) error {
+// ChangeAccountKey changes the key of an account
+func (s *Store) ChangeAccountKey(kid string, oldKey string, newKey string) error {
 	s.accmux.Lock()
 	defer s.accmux.Unlock()
 	i := -1
 	for j, a := range s.accounts {
+		if a.Key == newKey {
+			return fmt.Errorf("key already used by account %s", a.KeyID)
+		}
 		if a.KeyID == kid {
 			i = j
-			break
 		}
 	}
-	s.accounts[i].Status = "revoked"
-	log.Infof("Account (%d total) - revoked: %s", len(s.accounts), s.accounts[i].KeyID)
+	if i < 0 {
+		return fmt.Errorf("account %s not found", kid)
+	}
+	if s.accounts[i].Key != oldKey {
+		return fmt.Errorf("key of account %s changed", kid)
+	}
+	s.accounts[i].Key = newKey
+	log.Infof("Account (%d total) - key changed: %s", len(s.accounts), s.accounts[i].KeyID)
 	return nil
 }
 
+// RevokeAccount revokes an account (on admin/server request)
+func (s *Store) RevokeAccount(kid string) error {
+	return s.closeAccount(kid, "revoked")
+}
+
 // DeactivateAccount deactivas account (on user request)
 func (s *Store) DeactivateAccount(kid string) error {
+	return s.closeAccount(kid, "deactivated")
+}
+
+// closeAccount sets the final status of an account
+// and cancels its pending authorizations and orders
+func (s *Store) closeAccount(kid string, status string) error {
 	s.accmux.Lock()
-	defer s.accmux.Unlock()
 	i := -1
 	for j, a := range s.accounts {
 		if a.KeyID == kid {
@@ -113,7 +132,26 @@ func (s *Store) DeactivateAccount(kid string) error {
 			break
 		}
 	}
-	s.accounts[i].Status = "deactivated"
-	log.Infof("Account (%d total) - deactiveted: %s", len(s.accounts), s.accounts[i].KeyID)
+	if i < 0 {
+		s.accmux.Unlock()
+		return fmt.Errorf("account %s not found", kid)
+	}
+	s.accounts[i].Status = status
+	log.Infof("Account (%d total) - %s: %s", len(s.accounts), status, s.accounts[i].KeyID)
+	s.accmux.Unlock()
+	s.authzmux.Lock()
+	for j, a := range s.authzs {
+		if a.KeyID == kid && a.Status == "pending" {
+			s.authzs[j].Status = "deactivated"
+		}
+	}
+	s.authzmux.Unlock()
+	s.ordmux.Lock()
+	for j, o := range s.orders {
+		if o.KeyID == kid && (o.Status == "pending" || o.Status == "ready") {
+			s.orders[j].Status = "invalid"
+		}
+	}
+	s.ordmux.Unlock()
 	return nil
 }
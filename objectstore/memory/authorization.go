@@ -3,10 +3,19 @@ package memory
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/cblomart/ACMECA/objectstore/objects"
 )
 
+// CreateAuthorization creates an authorization and its challenges
+func (s *Store) CreateAuthorization(authz *objects.Authorization) error {
+	s.authzmux.Lock()
+	defer s.authzmux.Unlock()
+	s.authzs = append(s.authzs, *copyAuthorization(*authz))
+	return nil
+}
+
 // GetAuthorization retrieves an authorization
 func (s *Store) GetAuthorization(id string) (*objects.Authorization, error) {
 	s.authzmux.Lock()
@@ -19,7 +28,7 @@ func (s *Store) GetAuthorization(id string) (*objects.Authorization, error) {
 		}
 	}
 	if i >= 0 {
-		return &s.authzs[i], nil
+		return copyAuthorization(s.authzs[i]), nil
 	}
 	return nil, nil
 }
@@ -41,13 +50,79 @@ func (s *Store) GetAuthorizationByChallenge(id string) (*objects.Authorization,
 		}
 	}
 	if i >= 0 {
-		return &s.authzs[i], nil
+		return copyAuthorization(s.authzs[i]), nil
 	}
 	return nil, nil
 }
 
 // UpdateAuthorization updates the authrorization
 func (s *Store) UpdateAuthorization(authz *objects.Authorization) error {
-	// nothing to be done in memory
-	return nil
+	s.authzmux.Lock()
+	defer s.authzmux.Unlock()
+	for i, a := range s.authzs {
+		if a.ID == authz.ID {
+			s.authzs[i] = *copyAuthorization(*authz)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot find authorization %s", authz.ID)
+}
+
+// CompareAndUpdateAuthorization updates the authorization if its status did not change
+func (s *Store) CompareAndUpdateAuthorization(authz *objects.Authorization, status string) (bool, error) {
+	s.authzmux.Lock()
+	defer s.authzmux.Unlock()
+	for i, a := range s.authzs {
+		if a.ID == authz.ID {
+			if a.Status != status {
+				return false, nil
+			}
+			s.authzs[i] = *copyAuthorization(*authz)
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("cannot find authorization %s", authz.ID)
+}
+
+// copyAuthorization copies an authorization so that it can be modified
+// outside of the lock (validations run in the background)
+func copyAuthorization(authz objects.Authorization) *objects.Authorization {
+	challenges := make([]objects.Challenge, len(authz.Challenges))
+	copy(challenges, authz.Challenges)
+	authz.Challenges = challenges
+	return &authz
+}
+
+// ExpireAuthorizations expires the pending and valid authorizations past their expiry
+func (s *Store) ExpireAuthorizations(now time.Time) (int, error) {
+	s.authzmux.Lock()
+	defer s.authzmux.Unlock()
+	count := 0
+	for i, a := range s.authzs {
+		if a.Expires.After(now) {
+			continue
+		}
+		switch a.Status {
+		case "pending", "valid":
+			s.authzs[i].Status = "expired"
+			count++
+		}
+	}
+	return count, nil
+}
+
+// PurgeAuthorizations removes the authorizations no longer usable that expired before a date
+func (s *Store) PurgeAuthorizations(before time.Time) (int, error) {
+	s.authzmux.Lock()
+	defer s.authzmux.Unlock()
+	authzs := make([]objects.Authorization, 0, len(s.authzs))
+	for _, a := range s.authzs {
+		if a.Status != "pending" && a.Status != "valid" && a.Expires.Before(before) {
+			continue
+		}
+		authzs = append(authzs, a)
+	}
+	count := len(s.authzs) - len(authzs)
+	s.authzs = authzs
+	return count, nil
 }
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/cblomart/ACMECA/objectstore/objects"
+)
+
+// CreateExternalAccountKey creates an external account key
+func (s *Store) CreateExternalAccountKey(key objects.ExternalAccountKey) error {
+	s.eabmux.Lock()
+	defer s.eabmux.Unlock()
+	for _, k := range s.eabkeys {
+		if k.ID == key.ID {
+			return fmt.Errorf("external account key already exists")
+		}
+	}
+	s.eabkeys = append(s.eabkeys, key)
+	return nil
+}
+
+// GetExternalAccountKey gets an external account key
+func (s *Store) GetExternalAccountKey(id string) (*objects.ExternalAccountKey, error) {
+	s.eabmux.Lock()
+	defer s.eabmux.Unlock()
+	for i, k := range s.eabkeys {
+		if k.ID == id {
+			return &s.eabkeys[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetExternalAccountKeys lists the external account keys
+func (s *Store) GetExternalAccountKeys() ([]objects.ExternalAccountKey, error) {
+	s.eabmux.Lock()
+	defer s.eabmux.Unlock()
+	keys := make([]objects.ExternalAccountKey, len(s.eabkeys))
+	copy(keys, s.eabkeys)
+	return keys, nil
+}
+
+// DeleteExternalAccountKey removes an external account key
+func (s *Store) DeleteExternalAccountKey(id string) error {
+	s.eabmux.Lock()
+	defer s.eabmux.Unlock()
+	i := -1
+	for j, k := range s.eabkeys {
+		if k.ID == id {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("external account key %s not found", id)
+	}
+	s.eabkeys[i] = s.eabkeys[len(s.eabkeys)-1]
+	s.eabkeys = s.eabkeys[:len(s.eabkeys)-1]
+	return nil
+}
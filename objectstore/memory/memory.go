@@ -10,12 +10,16 @@ import (
 type Store struct {
 	accounts   []objects.Account
 	accmux     sync.Mutex
+	eabkeys    []objects.ExternalAccountKey
+	eabmux     sync.Mutex
 	orders     []objects.Order
 	ordmux     sync.Mutex
 	authzs     []objects.Authorization
 	authzmux   sync.Mutex
 	challenges []objects.Challenge
 	chamux     sync.Mutex
+	rateevents []objects.RateEvent
+	ratemux    sync.Mutex
 }
 
 // Type returns the storage type
@@ -2,7 +2,9 @@ package memory
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/cblomart/ACMECA/objectstore/objects"
 )
@@ -45,9 +47,9 @@ func (s *Store) createAuthorizations(order *objects.Order, authzURL string, chal
 	return nil
 }
 
+// getOrder returns the index of an order (-1 if not found)
+// orders must be locked by the caller as purges move them
 func (s *Store) getOrder(id string) int {
-	s.ordmux.Lock()
-	defer s.ordmux.Unlock()
 	i := -1
 	for j, o := range s.orders {
 		if o.ID == id {
@@ -58,13 +60,17 @@ func (s *Store) getOrder(id string) int {
 	return i
 }
 
-// GetOrder gets an order
+// GetOrder gets a copy of an order
+// changes are saved with UpdateOrder
 func (s *Store) GetOrder(id string, authzPath string) (*objects.Order, error) {
+	s.ordmux.Lock()
+	defer s.ordmux.Unlock()
 	i := s.getOrder(id)
-	if i >= 0 {
-		return &s.orders[i], nil
+	if i < 0 {
+		return nil, nil
 	}
-	return nil, nil
+	order := s.orders[i]
+	return &order, nil
 }
 
 // GetOrderByAccount gets orders from an account
@@ -80,9 +86,32 @@ func (s *Store) GetOrderByAccount(id string) ([]objects.Order, error) {
 	return orders, nil
 }
 
+// ListOrders lists ids of the orders of an account that are not invalid
+func (s *Store) ListOrders(kid string, cursor string, limit int) ([]string, error) {
+	ids := make([]string, 0)
+	s.ordmux.Lock()
+	defer s.ordmux.Unlock()
+	for _, o := range s.orders {
+		if o.KeyID == kid && o.Status != "invalid" && o.ID > cursor {
+			ids = append(ids, o.ID)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
 // UpdateOrder updates an order
 func (s *Store) UpdateOrder(order *objects.Order) error {
-	// nothing to be made in memory
+	s.ordmux.Lock()
+	defer s.ordmux.Unlock()
+	i := s.getOrder(order.ID)
+	if i < 0 {
+		return fmt.Errorf("order %s not found", order.ID)
+	}
+	s.orders[i] = *order
 	return nil
 }
 
@@ -106,18 +135,62 @@ func (s *Store) GetOrderByAuthorization(id string) ([]objects.Order, error) {
 
 // InvalidateOrder invalidates an order
 func (s *Store) InvalidateOrder(id string) error {
-	i := s.getOrder(id)
 	s.ordmux.Lock()
 	defer s.ordmux.Unlock()
+	i := s.getOrder(id)
+	if i < 0 {
+		return fmt.Errorf("order %s not found", id)
+	}
 	s.orders[i].Status = "invalid"
 	return nil
 }
 
 // ReadyOrder readies an order
 func (s *Store) ReadyOrder(id string) error {
-	i := s.getOrder(id)
 	s.ordmux.Lock()
 	defer s.ordmux.Unlock()
+	i := s.getOrder(id)
+	if i < 0 {
+		return fmt.Errorf("order %s not found", id)
+	}
 	s.orders[i].Status = "ready"
 	return nil
 }
+
+// ExpireOrders invalidates the orders not finalized before their expiry
+func (s *Store) ExpireOrders(now time.Time) (int, error) {
+	s.ordmux.Lock()
+	defer s.ordmux.Unlock()
+	count := 0
+	for i, o := range s.orders {
+		if o.Expires == nil || o.Expires.After(now) {
+			continue
+		}
+		switch o.Status {
+		case "pending", "ready", "processing":
+			s.orders[i].Status = "invalid"
+			count++
+		}
+	}
+	return count, nil
+}
+
+// PurgeOrders removes the invalid orders that expired before a date
+// and the valid orders which certificate expired before it
+func (s *Store) PurgeOrders(before time.Time) (int, error) {
+	s.ordmux.Lock()
+	defer s.ordmux.Unlock()
+	orders := make([]objects.Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		if o.Status == "invalid" && o.Expires != nil && o.Expires.Before(before) {
+			continue
+		}
+		if o.Status == "valid" && o.CertificateExpires != nil && o.CertificateExpires.Before(before) {
+			continue
+		}
+		orders = append(orders, o)
+	}
+	count := len(s.orders) - len(orders)
+	s.orders = orders
+	return count, nil
+}
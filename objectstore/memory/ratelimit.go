@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cblomart/ACMECA/objectstore/objects"
+)
+
+// AddRateEvent records an event for a rate limit
+func (s *Store) AddRateEvent(bucket string, key string, at time.Time) error {
+	s.ratemux.Lock()
+	defer s.ratemux.Unlock()
+	s.rateevents = append(s.rateevents, objects.RateEvent{Bucket: bucket, Key: key, Time: at})
+	return nil
+}
+
+// CountRateEvents counts the events of a rate limit since a date
+// and returns the date of the event after which less than limit events remain
+func (s *Store) CountRateEvents(bucket string, key string, since time.Time, limit int) (int, time.Time, error) {
+	s.ratemux.Lock()
+	defer s.ratemux.Unlock()
+	times := make([]time.Time, 0)
+	for _, e := range s.rateevents {
+		if e.Bucket != bucket || e.Key != key || e.Time.Before(since) {
+			continue
+		}
+		times = append(times, e.Time)
+	}
+	if len(times) < limit || limit <= 0 {
+		return len(times), time.Time{}, nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return len(times), times[len(times)-limit], nil
+}
+
+// PurgeRateEvents removes the events older than a date
+func (s *Store) PurgeRateEvents(before time.Time) (int, error) {
+	s.ratemux.Lock()
+	defer s.ratemux.Unlock()
+	kept := s.rateevents[:0]
+	for _, e := range s.rateevents {
+		if e.Time.Before(before) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	count := len(s.rateevents) - len(kept)
+	s.rateevents = kept
+	return count, nil
+}
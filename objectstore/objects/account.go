@@ -8,6 +8,7 @@ type Account struct {
 	Contact              []string `json:"contact"`
 	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed" xorm:"tos"`
 	Orders               string   `json:"orders"`
+	ExternalAccountID    string   `json:"-" xorm:"eabkid index"`
 }
 
 // Check checks if an account is valid
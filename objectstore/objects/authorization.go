@@ -2,9 +2,12 @@ package objects
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/cblomart/ACMECA/acme/problem"
 	"github.com/cblomart/ACMECA/acme/validator"
+	"github.com/cblomart/ACMECA/objectstore/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,6 +25,47 @@ type Authorization struct {
 	Status       string      `json:"status"`
 	Expires      time.Time   `json:"expires"`
 	Challenges   []Challenge `json:"challenges" xorm:"-"`
+	Wildcard     bool        `json:"wildcard,omitempty" xorm:"wildcard"`
+}
+
+// NewAuthorization creates a pending authorization and its challenges for an identifier
+// wildcard identifiers are authorized on their base domain with wildcard challenges
+func NewAuthorization(id Identifier, kid string, challengeURL string) (*Authorization, error) {
+	a := &Authorization{
+		ID:         utils.ID(),
+		KeyID:      kid,
+		Identifier: id,
+		Status:     "pending",
+		Expires:    time.Now().Add(time.Hour * 24 * AuthorizationValidity),
+	}
+	if base, wildcard := id.Base(); wildcard {
+		a.Identifier = Identifier{Type: id.Type, Value: base}
+		a.Wildcard = true
+	}
+	// create challenges for each supported challenges
+	challengeTypes := strings.Split(AllowedChallengeTypes, ",")
+	switch {
+	case id.Type == "ip":
+		challengeTypes = strings.Split(IPChallengeTypes, ",")
+	case a.Wildcard:
+		challengeTypes = strings.Split(WildcardChallengeTypes, ",")
+	}
+	a.Challenges = make([]Challenge, len(challengeTypes))
+	for i, t := range challengeTypes {
+		challenge, err := NewChallenge(challengeURL, t, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error creating challenge: %s", err)
+		}
+		a.Challenges[i] = *challenge
+	}
+	return a, nil
+}
+
+// Authorizes indicates if the authorization is for an identifier of an order
+// wildcard identifiers are authorized on their base domain (RFC 8555 §7.1.3)
+func (a *Authorization) Authorizes(id Identifier) bool {
+	base, wildcard := id.Base()
+	return a.Identifier.Type == id.Type && a.Wildcard == wildcard && strings.EqualFold(a.Identifier.Value, base)
 }
 
 func (a *Authorization) String() string {
@@ -41,40 +85,70 @@ func (a *Authorization) String() string {
 	return fmt.Sprintf("Authorization %s for %s (%d valid, %d invalid, %d pending): %s", a.ID, a.Identifier.String(), valid, invalid, pending, a.Status)
 }
 
-//Validate validates a challenge from an authrorization
-func (a *Authorization) Validate(id string, key string) *Challenge {
-	// get the proper challenge in the authorization
-	found := -1
+// Challenge gets a challenge of the authorization from its id
+func (a *Authorization) Challenge(id string) *Challenge {
 	for i, c := range a.Challenges {
 		if c.ID == id {
-			found = i
-			break
+			return &a.Challenges[i]
 		}
 	}
-	challenge := &a.Challenges[found]
-	if challenge.Status != "pending" {
-		return challenge
+	return nil
+}
+
+// Process marks a pending challenge as being processed
+func (a *Authorization) Process(id string) *Challenge {
+	challenge := a.Challenge(id)
+	if challenge == nil {
+		return nil
+	}
+	if challenge.Status == "pending" && a.Status == "pending" {
+		challenge.Status = "processing"
+		challenge.Error = nil
+	}
+	return challenge
+}
+
+// Deactivate deactivates a pending or valid authorization (RFC 8555 §7.5.2)
+// returns false if the authorization cannot be deactivated
+func (a *Authorization) Deactivate() bool {
+	if a.Status != "pending" && a.Status != "valid" {
+		return false
+	}
+	a.Status = "deactivated"
+	return true
+}
+
+// Validate attempts the validation of a challenge from an authorization
+// the state of the challenge is not changed
+func (a *Authorization) Validate(id string, key string) (string, *problem.Problem) {
+	challenge := a.Challenge(id)
+	if challenge == nil {
+		return "invalid", problem.NewMalformed(fmt.Sprintf("unknown challenge %s", id))
 	}
-	challenge.Status = "processing"
 	log.Infof("validating challenge %s for identity %s with %s", id, a.Identifier.String(), challenge.Type)
-	challenge.Status = validator.Validate(a.Identifier.Value, challenge.Type, challenge.Token, key)
+	return validator.Validate(a.Identifier.Value, challenge.Type, challenge.Token, key)
+}
+
+// Complete sets the final status of a challenge and updates the authorization
+func (a *Authorization) Complete(id string, status string, prob *problem.Problem) *Challenge {
+	challenge := a.Challenge(id)
+	if challenge == nil {
+		return nil
+	}
+	challenge.Status = status
+	challenge.Error = prob
+	now := time.Now()
+	challenge.Validated = &now
 	if a.Status == "pending" {
-		a.Status = challenge.Status
-		if challenge.Status == "valid" || challenge.Status == "invalid" {
-			now := time.Now()
-			challenge.Validated = &now
-			pending := make([]int, 0)
-			for i, c := range a.Challenges {
-				if c.Status == "pending" {
-					pending = append(pending, i)
-				}
-			}
-			// remove pending challenges
-			for i, j := range pending {
-				a.Challenges[j] = a.Challenges[len(a.Challenges)-1-i]
+		a.Status = status
+		// remove pending challenges
+		challenges := make([]Challenge, 0, len(a.Challenges))
+		for _, c := range a.Challenges {
+			if c.Status != "pending" {
+				challenges = append(challenges, c)
 			}
-			a.Challenges = a.Challenges[:len(a.Challenges)-len(pending)]
 		}
+		a.Challenges = challenges
 	}
-	return challenge
+	return a.Challenge(id)
 }
@@ -14,6 +14,10 @@ import (
 const (
 	// AllowedChallengeTypes list the allowed challenge type (comma separated)
 	AllowedChallengeTypes = "http-01,tls-alpn-01,dns-01"
+	// IPChallengeTypes list the allowed challenge type for ip identifiers (RFC 8738 §7)
+	IPChallengeTypes = "http-01,tls-alpn-01"
+	// WildcardChallengeTypes list the allowed challenge type for wildcard identifiers (RFC 8555 §7.1.3)
+	WildcardChallengeTypes = "dns-01"
 	// TokenLength is the length of the token for validation
 	TokenLength = 128
 )
@@ -26,7 +30,7 @@ type Challenge struct {
 	URL           string           `json:"url" xorm:"url"`
 	Status        string           `json:"status"`
 	Validated     *time.Time       `json:"validated,omitempty"`
-	Error         *problem.Problem `json:"error,omitempty"`
+	Error         *problem.Problem `json:"error,omitempty" xorm:"json"`
 	Token         string           `json:"token"`
 }
 
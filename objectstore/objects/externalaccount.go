@@ -0,0 +1,13 @@
+package objects
+
+import (
+	"time"
+)
+
+// ExternalAccountKey is a MAC key binding ACME accounts to an external account
+type ExternalAccountKey struct {
+	ID          string    `json:"id" xorm:"id pk"`
+	Key         string    `json:"key,omitempty" xorm:"hmac"`
+	Description string    `json:"description"`
+	Created     time.Time `json:"created"`
+}
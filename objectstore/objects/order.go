@@ -7,7 +7,6 @@ import (
 
 	"github.com/cblomart/ACMECA/acme/problem"
 	"github.com/cblomart/ACMECA/acme/validator"
-	"github.com/cblomart/ACMECA/objectstore/utils"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -32,12 +31,24 @@ type Order struct {
 	Authorizations []string         `json:"authorizations" xorm:"-"`
 	Finalize       string           `json:"finalize"`
 	Certificate    string           `json:"certificate,omitempty"`
+	// CertificateExpires bounds the expiry of the certificate of a valid order
+	// the order is kept until then so that the account can revoke it
+	CertificateExpires *time.Time `json:"-" xorm:"certificate_expires index"`
 }
 
 func (i *Identifier) String() string {
 	return fmt.Sprintf("%s:%s", i.Type, i.Value)
 }
 
+// Base returns the value of the identifier without wildcard prefix
+// and if the identifier is a wildcard
+func (i *Identifier) Base() (string, bool) {
+	if i.Type == "dns" && strings.HasPrefix(i.Value, "*.") {
+		return strings.TrimPrefix(i.Value, "*."), true
+	}
+	return i.Value, false
+}
+
 // CheckOrder check orders
 func (o *Order) CheckOrder() (error, error) {
 	// identifiers to string array
@@ -65,12 +76,14 @@ func (o *Order) CreateAuthz(currauthz []Authorization, authzURL string, challeng
 	ids := make([]Identifier, len(o.Identitifers))
 	copy(ids, o.Identitifers)
 	// check valid authz for the object
+	now := time.Now()
 	for _, authz := range currauthz {
 		found := -1
 		// search for validated identifiers
 		for i, id := range ids {
-			if authz.Identifier.String() == id.String() &&
-				authz.Status == "valid" {
+			if authz.Authorizes(id) &&
+				authz.Status == "valid" &&
+				authz.Expires.After(now) {
 				found = i
 				break
 			}
@@ -85,30 +98,22 @@ func (o *Order) CreateAuthz(currauthz []Authorization, authzURL string, challeng
 			continue
 		}
 	}
+	// order is ready when all identifiers are already authorized
+	if len(ids) == 0 {
+		o.Status = "ready"
+	}
 	// remainging identifiers needs an authorization
 	// create an array for new authorizations created
 	newauthzs := make([]Authorization, len(ids))
 	// parse identifiers to create authorization
 	for i, id := range ids {
-		a := Authorization{}
-		a.Identifier = id
-		a.Expires = time.Now().Add(time.Hour * 24 * AuthorizationValidity)
-		a.KeyID = o.KeyID
-		a.Status = "pending"
-		a.ID = utils.ID()
-		// create challenges for each supported challenges
-		challengeTypes := strings.Split(AllowedChallengeTypes, ",")
-		a.Challenges = make([]Challenge, len(challengeTypes))
-		for i, t := range challengeTypes {
-			challenge, err := NewChallenge(challengeURL, t, a.ID)
-			if err != nil {
-				return nil, fmt.Errorf("error creating challenge: %s", err)
-			}
-			a.Challenges[i] = *challenge
+		a, err := NewAuthorization(id, o.KeyID, challengeURL)
+		if err != nil {
+			return nil, err
 		}
 		// add to authorizations urls
 		o.Authorizations = append(o.Authorizations, fmt.Sprintf("%s/%s", authzURL, a.ID))
-		newauthzs[i] = a
+		newauthzs[i] = *a
 	}
 	return newauthzs, nil
 }
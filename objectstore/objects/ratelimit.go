@@ -0,0 +1,13 @@
+package objects
+
+import (
+	"time"
+)
+
+// RateEvent is an event counted by a rate limit
+type RateEvent struct {
+	ID     int64     `json:"-" xorm:"id pk autoincr notnull"`
+	Bucket string    `json:"bucket" xorm:"bucket index"`
+	Key    string    `json:"key" xorm:"subject index"`
+	Time   time.Time `json:"time" xorm:"at index"`
+}
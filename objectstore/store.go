@@ -2,6 +2,7 @@ package objectstore
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cblomart/ACMECA/objectstore/memory"
 	"github.com/cblomart/ACMECA/objectstore/objects"
@@ -33,11 +34,25 @@ type ObjectStore interface {
 	CreateAccount(account objects.Account) error
 	// UpdateAccount updates an account
 	UpdateAccount(account objects.Account) (*objects.Account, error)
+	// ChangeAccountKey replaces the key of an account if it is still the old key
+	// and if the new key is not used by another account
+	ChangeAccountKey(kid string, oldKey string, newKey string) error
 	// RevokeAccount revokes an account (on admin/server request)
 	RevokeAccount(kid string) error
 	// DeactivateAccount deactivas account (on user request)
 	DeactivateAccount(kid string) error
 
+	// External account management
+
+	// CreateExternalAccountKey creates an external account key
+	CreateExternalAccountKey(key objects.ExternalAccountKey) error
+	// GetExternalAccountKey gets an external account key
+	GetExternalAccountKey(id string) (*objects.ExternalAccountKey, error)
+	// GetExternalAccountKeys lists the external account keys
+	GetExternalAccountKeys() ([]objects.ExternalAccountKey, error)
+	// DeleteExternalAccountKey removes an external account key
+	DeleteExternalAccountKey(id string) error
+
 	// Order manangement
 
 	// CreateOrder creates an order
@@ -46,6 +61,9 @@ type ObjectStore interface {
 	GetOrder(id string, authzPath string) (*objects.Order, error)
 	// GetOrderByAccount gets orders from an account
 	GetOrderByAccount(id string) ([]objects.Order, error)
+	// ListOrders lists ids of the orders of an account that are not invalid
+	// by ascending id, starting after the cursor id and up to limit ids
+	ListOrders(kid string, cursor string, limit int) ([]string, error)
 	// GetOrderByAuthorization gets an order from an authorization
 	GetOrderByAuthorization(id string) ([]objects.Order, error)
 	// InvalidateOrder invalidates an order
@@ -57,12 +75,41 @@ type ObjectStore interface {
 
 	// Authorization management
 
+	// CreateAuthorization creates an authorization and its challenges
+	CreateAuthorization(authz *objects.Authorization) error
 	// GetAuthorization gets an authorization
 	GetAuthorization(id string) (*objects.Authorization, error)
 	// GetAuthorizationByChallenge gets an authorization form a challenge id
 	GetAuthorizationByChallenge(id string) (*objects.Authorization, error)
 	// UpdateAuthorization updates an authorization
 	UpdateAuthorization(authz *objects.Authorization) error
+	// CompareAndUpdateAuthorization updates an authorization only if its stored status
+	// is still the given status and returns if it was updated
+	CompareAndUpdateAuthorization(authz *objects.Authorization, status string) (bool, error)
+
+	// Rate limits
+
+	// AddRateEvent records an event for a rate limit
+	AddRateEvent(bucket string, key string, at time.Time) error
+	// CountRateEvents counts the events of a rate limit since a date
+	// and returns the date of the (count-limit+1)th oldest one: the event
+	// after which less than limit events remain (zero if below the limit)
+	CountRateEvents(bucket string, key string, since time.Time, limit int) (int, time.Time, error)
+
+	// Maintenance
+
+	// ExpireOrders invalidates the orders not finalized before their expiry
+	ExpireOrders(now time.Time) (int, error)
+	// ExpireAuthorizations expires the pending and valid authorizations past their expiry
+	ExpireAuthorizations(now time.Time) (int, error)
+	// PurgeOrders removes the invalid orders that expired before a date
+	// and the valid orders which certificate expired before it
+	PurgeOrders(before time.Time) (int, error)
+	// PurgeAuthorizations removes the authorizations (and their challenges)
+	// no longer usable that expired before a date
+	PurgeAuthorizations(before time.Time) (int, error)
+	// PurgeRateEvents removes the rate limit events older than a date
+	PurgeRateEvents(before time.Time) (int, error)
 }
 
 // Factory creates a store in function of its type
@@ -69,20 +69,80 @@ func (s *Store) UpdateAccount(account objects.Account) (*objects.Account, error)
 	return nil, nil
 }
 
-// RevokeAccount revokes an account (on admin/server request)
-func (s *Store) RevokeAccount(kid string) error {
-	_, err := s.engine.Update(&objects.Account{Status: "revoked"}, &objects.Account{KeyID: kid})
+// ChangeAccountKey changes the key of an account
+func (s *Store) ChangeAccountKey(kid string, oldKey string, newKey string) error {
+	session := s.engine.NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %s", err)
+	}
+	exists, err := session.Where("key = ?", newKey).Exist(&objects.Account{})
 	if err != nil {
-		return fmt.Errorf("couldn't retrieve account %s: %s", kid, err)
+		session.Rollback()
+		return fmt.Errorf("error checking for new key: %s", err)
+	}
+	if exists {
+		session.Rollback()
+		return fmt.Errorf("key already used by another account")
+	}
+	affected, err := session.Where("keyid = ? and key = ?", kid, oldKey).Cols("key").Update(&objects.Account{Key: newKey})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot change key of account %s: %s", kid, err)
+	}
+	if affected != 1 {
+		session.Rollback()
+		return fmt.Errorf("key of account %s changed", kid)
+	}
+	err = session.Commit()
+	if err != nil {
+		return fmt.Errorf("cannot commit key change of account %s: %s", kid, err)
 	}
 	return nil
 }
 
+// RevokeAccount revokes an account (on admin/server request)
+func (s *Store) RevokeAccount(kid string) error {
+	return s.closeAccount(kid, "revoked")
+}
+
 // DeactivateAccount deactivas account (on user request)
 func (s *Store) DeactivateAccount(kid string) error {
-	_, err := s.engine.Update(&objects.Account{Status: "revoked"}, &objects.Account{KeyID: kid})
+	return s.closeAccount(kid, "deactivated")
+}
+
+// closeAccount sets the final status of an account
+// and cancels its pending authorizations and orders
+func (s *Store) closeAccount(kid string, status string) error {
+	session := s.engine.NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %s", err)
+	}
+	affected, err := session.Where("keyid = ?", kid).Cols("status").Update(&objects.Account{Status: status})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot update status of account %s: %s", kid, err)
+	}
+	if affected == 0 {
+		session.Rollback()
+		return fmt.Errorf("account %s not found", kid)
+	}
+	_, err = session.Where("keyid = ? and status = ?", kid, "pending").Cols("status").Update(&objects.Authorization{Status: "deactivated"})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot deactivate authorizations of account %s: %s", kid, err)
+	}
+	_, err = session.Where("keyid = ?", kid).In("status", "pending", "ready").Cols("status").Update(&objects.Order{Status: "invalid"})
+	if err != nil {
+		session.Rollback()
+		return fmt.Errorf("cannot invalidate orders of account %s: %s", kid, err)
+	}
+	err = session.Commit()
 	if err != nil {
-		return fmt.Errorf("couldn't retrieve account %s: %s", kid, err)
+		return fmt.Errorf("cannot commit status of account %s: %s", kid, err)
 	}
 	return nil
 }
@@ -2,11 +2,46 @@ package xorm
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cblomart/ACMECA/objectstore/objects"
 	log "github.com/sirupsen/logrus"
 )
 
+// CreateAuthorization creates an authorization and its challenges
+func (s *Store) CreateAuthorization(authz *objects.Authorization) error {
+	// search if identifier exists
+	var id objects.Identifier
+	ok, err := s.engine.Where("type = ?", authz.Identifier.Type).And("value = ?", authz.Identifier.Value).Get(&id)
+	if err != nil {
+		return fmt.Errorf("failed to get identifier %s: %s", authz.Identifier.String(), err)
+	}
+	if ok {
+		log.Infof("updating authz with identifier %s with id %d", id.String(), id.ID)
+		authz.Identifier = id
+	} else {
+		// create new identifier
+		_, err = s.engine.Insert(&authz.Identifier)
+		if err != nil {
+			return fmt.Errorf("cannot create identifier %s: %s", authz.Identifier.String(), err)
+		}
+		log.Infof("updating authz with new identifier %s with id %d", authz.Identifier.String(), authz.Identifier.ID)
+	}
+	authz.IdentifierID = authz.Identifier.ID
+	// create challenges
+	affected, err := s.engine.Insert(authz.Challenges)
+	if err != nil {
+		return fmt.Errorf("cannot create challenges for %s: %s", authz.ID, err)
+	}
+	log.Infof("insert of %d challenges affected %d rows", len(authz.Challenges), affected)
+	// save authorization
+	_, err = s.engine.Insert(authz)
+	if err != nil {
+		return fmt.Errorf("cannot insert authz %s: %s", authz.ID, err)
+	}
+	return nil
+}
+
 // GetAuthorization retrieves an authorization
 func (s *Store) GetAuthorization(id string) (*objects.Authorization, error) {
 	var authz objects.Authorization
@@ -82,7 +117,8 @@ func (s *Store) UpdateAuthorization(authz *objects.Authorization) error {
 	var challengeIds []string
 	for _, challenge := range authz.Challenges {
 		challengeIds = append(challengeIds, challenge.ID)
-		_, err := s.engine.Update(challenge, &objects.Challenge{ID: challenge.ID})
+		// all columns so that cleared errors are saved
+		_, err := s.engine.Where("id = ?", challenge.ID).AllCols().Update(&challenge)
 		if err != nil {
 			return fmt.Errorf("cannot update challenge %s of authz %s: %s", challenge.ID, authz.ID, err)
 		}
@@ -117,3 +153,94 @@ func (s *Store) UpdateAuthorization(authz *objects.Authorization) error {
 	}
 	return nil
 }
+
+// CompareAndUpdateAuthorization updates the authorization and its challenges if its status did not change
+func (s *Store) CompareAndUpdateAuthorization(authz *objects.Authorization, status string) (bool, error) {
+	session := s.engine.NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return false, fmt.Errorf("cannot start transaction: %s", err)
+	}
+	affected, err := session.Where("id = ? and status = ?", authz.ID, status).Update(authz)
+	if err != nil {
+		session.Rollback()
+		return false, fmt.Errorf("cannot update authorization %s: %s", authz.ID, err)
+	}
+	if affected == 0 {
+		// some databases do not count unchanged rows
+		ok, err := session.Where("id = ? and status = ?", authz.ID, status).Exist(&objects.Authorization{})
+		if err != nil {
+			session.Rollback()
+			return false, fmt.Errorf("cannot check status of authorization %s: %s", authz.ID, err)
+		}
+		if !ok {
+			session.Rollback()
+			return false, nil
+		}
+	}
+	for _, challenge := range authz.Challenges {
+		// all columns so that cleared errors are saved
+		_, err := session.Where("id = ?", challenge.ID).AllCols().Update(&challenge)
+		if err != nil {
+			session.Rollback()
+			return false, fmt.Errorf("cannot update challenge %s of authz %s: %s", challenge.ID, authz.ID, err)
+		}
+	}
+	err = session.Commit()
+	if err != nil {
+		return false, fmt.Errorf("cannot commit authorization %s: %s", authz.ID, err)
+	}
+	return true, nil
+}
+
+// ExpireAuthorizations expires the pending and valid authorizations past their expiry
+func (s *Store) ExpireAuthorizations(now time.Time) (int, error) {
+	affected, err := s.engine.Where("expires <= ?", now).In("status", "pending", "valid").Cols("status").Update(&objects.Authorization{Status: "expired"})
+	if err != nil {
+		return 0, fmt.Errorf("cannot expire authorizations: %s", err)
+	}
+	return int(affected), nil
+}
+
+// PurgeAuthorizations removes the authorizations no longer usable that expired before a date
+func (s *Store) PurgeAuthorizations(before time.Time) (int, error) {
+	var authzs []objects.Authorization
+	err := s.engine.Where("expires < ?", before).NotIn("status", "pending", "valid").Cols("id").Find(&authzs)
+	if err != nil {
+		return 0, fmt.Errorf("cannot find authorizations to purge: %s", err)
+	}
+	if len(authzs) == 0 {
+		return 0, nil
+	}
+	ids := make([]string, len(authzs))
+	for i, a := range authzs {
+		ids[i] = a.ID
+	}
+	session := s.engine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("cannot start transaction: %s", err)
+	}
+	_, err = session.In("authorization", ids).Delete(&objects.Challenge{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot remove challenges of purged authorizations: %s", err)
+	}
+	_, err = session.In("authorization_id", ids).Delete(&OrdersToAuthorizations{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot remove links of purged authorizations: %s", err)
+	}
+	affected, err := session.In("id", ids).Delete(&objects.Authorization{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot purge authorizations: %s", err)
+	}
+	err = session.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit purge of authorizations: %s", err)
+	}
+	return int(affected), nil
+}
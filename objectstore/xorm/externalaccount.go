@@ -0,0 +1,58 @@
+package xorm
+
+import (
+	"fmt"
+
+	"github.com/cblomart/ACMECA/objectstore/objects"
+)
+
+// CreateExternalAccountKey creates an external account key
+func (s *Store) CreateExternalAccountKey(key objects.ExternalAccountKey) error {
+	exists, err := s.engine.Where("id = ?", key.ID).Exist(&objects.ExternalAccountKey{})
+	if err != nil {
+		return fmt.Errorf("error checking for external account key %s: %s", key.ID, err)
+	}
+	if exists {
+		return fmt.Errorf("external account key already exists")
+	}
+	_, err = s.engine.Insert(&key)
+	if err != nil {
+		return fmt.Errorf("cannot insert external account key %s: %s", key.ID, err)
+	}
+	return nil
+}
+
+// GetExternalAccountKey gets an external account key
+func (s *Store) GetExternalAccountKey(id string) (*objects.ExternalAccountKey, error) {
+	var key objects.ExternalAccountKey
+	ok, err := s.engine.Where("id = ?", id).Get(&key)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retrieve external account key %s: %s", id, err)
+	}
+	if ok {
+		return &key, nil
+	}
+	return nil, nil
+}
+
+// GetExternalAccountKeys lists the external account keys
+func (s *Store) GetExternalAccountKeys() ([]objects.ExternalAccountKey, error) {
+	var keys []objects.ExternalAccountKey
+	err := s.engine.Find(&keys)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list external account keys: %s", err)
+	}
+	return keys, nil
+}
+
+// DeleteExternalAccountKey removes an external account key
+func (s *Store) DeleteExternalAccountKey(id string) error {
+	affected, err := s.engine.Delete(&objects.ExternalAccountKey{ID: id})
+	if err != nil {
+		return fmt.Errorf("cannot delete external account key %s: %s", id, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("external account key %s not found", id)
+	}
+	return nil
+}
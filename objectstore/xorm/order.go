@@ -2,6 +2,8 @@ package xorm
 
 import (
 	"fmt"
+	"path"
+	"time"
 
 	"github.com/cblomart/ACMECA/objectstore/objects"
 	log "github.com/sirupsen/logrus"
@@ -14,6 +16,13 @@ type OrdersToIdentifiers struct {
 	IdentifierID int64  `xorm:"identifier_id index notnull"`
 }
 
+// OrdersToAuthorizations links orders to authorizations
+type OrdersToAuthorizations struct {
+	ID              int64  `xorm:"id pk notnull autoincr"`
+	OrderID         string `xorm:"order_id index notnull"`
+	AuthorizationID string `xorm:"authorization_id index notnull"`
+}
+
 // CreateOrder creates an order
 func (s *Store) CreateOrder(order *objects.Order, authzURL string, challengeURL string, finalizeURL string) (error, error, error) {
 	rejected, unsupported := order.CheckOrder()
@@ -40,7 +49,12 @@ func (s *Store) CreateOrder(order *objects.Order, authzURL string, challengeURL
 				return nil, nil, fmt.Errorf("cannot get identifier %s: %s", id.String(), err)
 			}
 			if !ok {
-				return nil, nil, fmt.Errorf("cannot get identifier %s", id.String())
+				// identifier not created with authorizations (wildcards)
+				identifier = objects.Identifier{Type: id.Type, Value: id.Value}
+				_, err = s.engine.Insert(&identifier)
+				if err != nil {
+					return nil, nil, fmt.Errorf("cannot create identifier %s: %s", id.String(), err)
+				}
 			}
 			id.ID = identifier.ID
 		}
@@ -50,59 +64,45 @@ func (s *Store) CreateOrder(order *objects.Order, authzURL string, challengeURL
 			return nil, nil, fmt.Errorf("cannot link order %s to identifier %d: %s", order.ID, id.ID, err)
 		}
 	}
+	// link orders to authorizations
+	for _, authzURL := range order.Authorizations {
+		authzID := path.Base(authzURL)
+		_, err := s.engine.Insert(&OrdersToAuthorizations{OrderID: order.ID, AuthorizationID: authzID})
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot link order %s to authorization %s: %s", order.ID, authzID, err)
+		}
+	}
 	return nil, nil, nil
 }
 
 func (s *Store) createAuthorizations(order *objects.Order, authzURL string, challengeURL string) error {
 	// find valid authorizations for the account that ordered
 	var validAuthz []objects.Authorization
-	var authzs []objects.Authorization
-	err := s.engine.Where("keyid = ?", order.KeyID).And("status = ?", "valid").Find(&authzs)
+	err := s.engine.Where("keyid = ?", order.KeyID).And("status = ?", "valid").Find(&validAuthz)
 	if err != nil {
 		return fmt.Errorf("cannot search for valid authz for %s: %s", order.KeyID, err)
 	}
+	// fill in identifiers to match them with the order
+	for i := range validAuthz {
+		ok, err := s.engine.ID(validAuthz[i].IdentifierID).Get(&validAuthz[i].Identifier)
+		if err != nil {
+			return fmt.Errorf("couldn't get identifier %d: %s", validAuthz[i].IdentifierID, err)
+		}
+		if !ok {
+			log.Warnf("couldn't find identifier %d of authz %s", validAuthz[i].IdentifierID, validAuthz[i].ID)
+		}
+	}
 	// create authorizations for order
 	newauthzs, err := order.CreateAuthz(validAuthz, authzURL, challengeURL)
 	if err != nil {
 		return fmt.Errorf("cannot create authz for %s: %s", order.KeyID, err)
 	}
-	// save identifiers and challenges and update them
+	// save identifiers, challenges and authorizations
 	for i := range newauthzs {
-		// reference the authz
-		authz := &newauthzs[i]
-		// search if identifier exists
-		var id objects.Identifier
-		ok, err := s.engine.Where("type = ?", authz.Identifier.Type).And("value = ?", authz.Identifier.Value).Get(&id)
+		err = s.CreateAuthorization(&newauthzs[i])
 		if err != nil {
-			return fmt.Errorf("failed to get identifier %s: %s", authz.Identifier.String(), err)
-		}
-		if ok {
-			log.Infof("updating authz with identifier %s with id %d", id.String(), id.ID)
-			authz.Identifier = id
-			authz.IdentifierID = id.ID
-		} else {
-			// create new identifier
-			_, err = s.engine.Insert(&authz.Identifier)
-			if err != nil {
-				return fmt.Errorf("cannot create identifier %s: %s", authz.Identifier.String(), err)
-			}
-			log.Infof("updating authz with new identifier %s with id %d", authz.Identifier.String(), authz.Identifier.ID)
-			authz.IdentifierID = authz.Identifier.ID
+			return err
 		}
-		// create challenges
-		affected, err := s.engine.Insert(authz.Challenges)
-		if err != nil {
-			return fmt.Errorf("cannot create challenges for %s: %s", authz.ID, err)
-		}
-		log.Infof("insert of %d challenges affected %d rows", len(authz.Challenges), affected)
-	}
-	for _, authz := range newauthzs {
-		log.Infof("new authz with identifier %s and id %d", authz.Identifier.String(), authz.Identifier.ID)
-	}
-	// save authorizations
-	_, err = s.engine.Insert(newauthzs)
-	if err != nil {
-		return fmt.Errorf("cannot insert authz for %s: %s", order.KeyID, err)
 	}
 	return nil
 }
@@ -137,14 +137,52 @@ func (s *Store) GetOrder(id string, authzPath string) (*objects.Order, error) {
 	}
 	order.Identitifers = identifiers
 	// fill in authorizations
-	var authzs []objects.Authorization
-	err = s.engine.Where("keyid = ?", order.KeyID).In("identifierid", ids).Find(&authzs)
+	var authzLinks []OrdersToAuthorizations
+	err = s.engine.Find(&authzLinks, &OrdersToAuthorizations{OrderID: id})
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get authorization for order %s: %s", id, err)
+		return nil, fmt.Errorf("Cannot get order %s links to authorizations: %s", id, err)
 	}
-	authzUrls := make([]string, len(authzs))
-	for i, authz := range authzs {
-		authzUrls[i] = fmt.Sprintf("%s/%s", authzPath, authz.ID)
+	authzUrls := make([]string, len(authzLinks))
+	for i, link := range authzLinks {
+		authzUrls[i] = fmt.Sprintf("%s/%s", authzPath, link.AuthorizationID)
+	}
+	if len(authzLinks) == 0 {
+		// orders created before links to authorizations
+		// wildcard identifiers are authorized on their base domain
+		direct := make(map[int64]bool)
+		for _, i := range ids {
+			direct[i] = true
+		}
+		bases := []string{}
+		for _, identifier := range identifiers {
+			if base, wildcard := identifier.Base(); wildcard {
+				bases = append(bases, base)
+			}
+		}
+		wildcards := make(map[int64]bool)
+		if len(bases) > 0 {
+			var baseIdentifiers []objects.Identifier
+			err = s.engine.Where("type = ?", "dns").In("value", bases).Find(&baseIdentifiers)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot get base identifiers for order %s: %s", id, err)
+			}
+			for _, identifier := range baseIdentifiers {
+				wildcards[identifier.ID] = true
+				ids = append(ids, identifier.ID)
+			}
+		}
+		var authzs []objects.Authorization
+		err = s.engine.Where("keyid = ?", order.KeyID).In("identifierid", ids).Find(&authzs)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get authorization for order %s: %s", id, err)
+		}
+		authzUrls = []string{}
+		for _, authz := range authzs {
+			if (authz.Wildcard && !wildcards[authz.IdentifierID]) || (!authz.Wildcard && !direct[authz.IdentifierID]) {
+				continue
+			}
+			authzUrls = append(authzUrls, fmt.Sprintf("%s/%s", authzPath, authz.ID))
+		}
 	}
 	order.Authorizations = authzUrls
 	return &order, nil
@@ -160,6 +198,20 @@ func (s *Store) GetOrderByAccount(id string) ([]objects.Order, error) {
 	return orders, nil
 }
 
+// ListOrders lists ids of the orders of an account that are not invalid
+func (s *Store) ListOrders(kid string, cursor string, limit int) ([]string, error) {
+	var orders []objects.Order
+	err := s.engine.Where("keyid = ? and status <> ? and id > ?", kid, "invalid", cursor).Asc("id").Limit(limit).Cols("id").Find(&orders)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list orders for %s: %s", kid, err)
+	}
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+	}
+	return ids, nil
+}
+
 // UpdateOrder updates an order
 func (s *Store) UpdateOrder(order *objects.Order) error {
 	_, err := s.engine.Update(order, objects.Order{ID: order.ID})
@@ -180,9 +232,48 @@ func (s *Store) GetOrderByAuthorization(id string) ([]objects.Order, error) {
 	if !ok {
 		return nil, fmt.Errorf("could not find authz %s", id)
 	}
+	// find orders linked to the authz
+	var authzLinks []OrdersToAuthorizations
+	err = s.engine.Find(&authzLinks, &OrdersToAuthorizations{AuthorizationID: id})
+	if err != nil {
+		return nil, fmt.Errorf("could not get orders to authorizations links: %s", err)
+	}
+	if len(authzLinks) > 0 {
+		ids := make([]string, len(authzLinks))
+		for i, link := range authzLinks {
+			ids[i] = link.OrderID
+		}
+		var orders []objects.Order
+		err = s.engine.In("id", ids).Find(&orders)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get orders for authz %s: %s", id, err)
+		}
+		return orders, nil
+	}
+	// wildcard authorizations are linked to orders by the wildcard identifier
+	identifierID := authz.IdentifierID
+	if authz.Wildcard {
+		var base objects.Identifier
+		ok, err = s.engine.ID(authz.IdentifierID).Get(&base)
+		if err != nil {
+			return nil, fmt.Errorf("could not get identifier %d: %s", authz.IdentifierID, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("could not find identifier %d", authz.IdentifierID)
+		}
+		var wildcard objects.Identifier
+		ok, err = s.engine.Where("type = ?", base.Type).And("value = ?", "*."+base.Value).Get(&wildcard)
+		if err != nil {
+			return nil, fmt.Errorf("could not get wildcard identifier for %s: %s", base.String(), err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("could not find wildcard identifier for %s", base.String())
+		}
+		identifierID = wildcard.ID
+	}
 	// find authz linked to identity
 	var links []OrdersToIdentifiers
-	err = s.engine.Find(&links, &OrdersToIdentifiers{IdentifierID: authz.IdentifierID})
+	err = s.engine.Find(&links, &OrdersToIdentifiers{IdentifierID: identifierID})
 	if err != nil {
 		return nil, fmt.Errorf("could not get orders to identifers links: %s", err)
 	}
@@ -221,3 +312,56 @@ func (s *Store) ReadyOrder(id string) error {
 	}
 	return nil
 }
+
+// ExpireOrders invalidates the orders not finalized before their expiry
+func (s *Store) ExpireOrders(now time.Time) (int, error) {
+	affected, err := s.engine.Where("expires <= ?", now).In("status", "pending", "ready", "processing").Cols("status").Update(&objects.Order{Status: "invalid"})
+	if err != nil {
+		return 0, fmt.Errorf("cannot expire orders: %s", err)
+	}
+	return int(affected), nil
+}
+
+// PurgeOrders removes the invalid orders that expired before a date
+// and the valid orders which certificate expired before it
+// valid orders without certificate expiry (issued before it was kept) are not purged
+func (s *Store) PurgeOrders(before time.Time) (int, error) {
+	var orders []objects.Order
+	err := s.engine.Where("(status = ? and expires < ?) or (status = ? and certificate_expires < ?)", "invalid", before, "valid", before).Cols("id").Find(&orders)
+	if err != nil {
+		return 0, fmt.Errorf("cannot find orders to purge: %s", err)
+	}
+	if len(orders) == 0 {
+		return 0, nil
+	}
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+	}
+	session := s.engine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("cannot start transaction: %s", err)
+	}
+	_, err = session.In("order_id", ids).Delete(&OrdersToIdentifiers{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot remove links of purged orders: %s", err)
+	}
+	_, err = session.In("order_id", ids).Delete(&OrdersToAuthorizations{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot remove links of purged orders: %s", err)
+	}
+	affected, err := session.In("id", ids).Delete(&objects.Order{})
+	if err != nil {
+		session.Rollback()
+		return 0, fmt.Errorf("cannot purge orders: %s", err)
+	}
+	err = session.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit purge of orders: %s", err)
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,45 @@
+package xorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cblomart/ACMECA/objectstore/objects"
+)
+
+// AddRateEvent records an event for a rate limit
+func (s *Store) AddRateEvent(bucket string, key string, at time.Time) error {
+	_, err := s.engine.Insert(&objects.RateEvent{Bucket: bucket, Key: key, Time: at})
+	if err != nil {
+		return fmt.Errorf("cannot insert rate event %s for %s: %s", bucket, key, err)
+	}
+	return nil
+}
+
+// CountRateEvents counts the events of a rate limit since a date
+// and returns the date of the event after which less than limit events remain
+func (s *Store) CountRateEvents(bucket string, key string, since time.Time, limit int) (int, time.Time, error) {
+	count, err := s.engine.Where("bucket = ? and subject = ? and at >= ?", bucket, key, since).Count(&objects.RateEvent{})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("cannot count rate events %s for %s: %s", bucket, key, err)
+	}
+	if int(count) < limit || limit <= 0 {
+		return int(count), time.Time{}, nil
+	}
+	// skip the events that must leave the window with it
+	var event objects.RateEvent
+	_, err = s.engine.Where("bucket = ? and subject = ? and at >= ?", bucket, key, since).Asc("at").Limit(1, int(count)-limit).Get(&event)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("cannot get rate event %s for %s: %s", bucket, key, err)
+	}
+	return int(count), event.Time, nil
+}
+
+// PurgeRateEvents removes the events older than a date
+func (s *Store) PurgeRateEvents(before time.Time) (int, error) {
+	affected, err := s.engine.Where("at < ?", before).Delete(&objects.RateEvent{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge rate events: %s", err)
+	}
+	return int(affected), nil
+}
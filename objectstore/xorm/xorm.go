@@ -40,7 +40,7 @@ func (s *Store) Init(opts map[string]string) error {
 		return fmt.Errorf("could initiate xorm engine: %s", err)
 	}
 	s.engine = engine
-	err = s.engine.Sync2(new(objects.Account), new(objects.Identifier), new(objects.Order), new(objects.Authorization), new(objects.Challenge), new(OrdersToIdentifiers))
+	err = s.engine.Sync2(new(objects.Account), new(objects.Identifier), new(objects.Order), new(objects.Authorization), new(objects.Challenge), new(OrdersToIdentifiers), new(OrdersToAuthorizations), new(objects.ExternalAccountKey), new(objects.RateEvent))
 	if err != nil {
 		return fmt.Errorf("failed to sync to db: %s", err)
 	}